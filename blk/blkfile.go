@@ -21,11 +21,12 @@ const (
 // Entity format:
 // |VARINT(1-10 Bytes)|STRING(string length)|VARINT(1-10 Bytes)|DATA(data size)|
 type BlkFile struct {
-	file    BlockReadWriter
-	opener  Opener
-	version uint16
-	buf     []byte
-	cur     int64
+	file     BlockReadWriter
+	readerAt io.ReaderAt
+	opener   Opener
+	version  uint16
+	buf      []byte
+	cur      int64
 }
 
 func NewBlkFile(path string) *BlkFile {
@@ -50,6 +51,7 @@ func (bf *BlkFile) Open(flag flags.OpenFlag) error {
 		return err
 	}
 	bf.file = f
+	bf.readerAt = newReaderAt(f)
 	if !new {
 		if flag.CanRead() {
 			bf.cur = BlkFileHeadSize
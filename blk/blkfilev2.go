@@ -6,28 +6,99 @@
 package jengablk
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"github.com/xfali/jenga/compressor"
 	"github.com/xfali/jenga/flags"
 	"github.com/xfali/jenga/jengaerr"
+	"hash"
+	"hash/crc32"
 	"io"
 	"os"
+	"path"
+	"strings"
+	"sync"
 )
 
 const (
 	BlkFileV2Version uint16 = 0x0002
+	// 包含格式特性（Feature*）的V2格式文件的版本号，避免不识别格式特性的旧版本程序按V2实体格式误读
+	BlkFileV2FeatureVersion uint16 = 0x0004
 )
 
 // File format:
 // |MAGIC NUNMBER(2 Bytes)|VERSION(2 Bytes)|DATA FORMAT(2 Bytes)|REVERSE(2 Bytes)|ENTITY_1|ENTITY_2|...|ENTITY_N|
+// File format(FeatureDict):
+// |MAGIC NUNMBER(2 Bytes)|VERSION(2 Bytes)|DATA FORMAT(2 Bytes)|REVERSE(2 Bytes)|VARINT(dict size)|DICT(dict size)|ENTITY_1|...|ENTITY_N|
+// File format(FeatureEncrypt，加密信息见encryptHeader，DATA为压缩后加密的数据，FeatureEncryptKey时STRING为加密后的key):
+// |MAGIC NUNMBER(2 Bytes)|VERSION(2 Bytes)|DATA FORMAT(2 Bytes)|REVERSE(2 Bytes)|[VARINT(dict size)|DICT]|ENCRYPT HEADER|ENTITY_1|...|ENTITY_N|
 // Entity format:
 // |VARINT(1-10 Bytes)|STRING(string length)|DATA SIZE(8 Bytes)|DATA(data size)|
+// Entity format(FeatureChecksum):
+// |VARINT(1-10 Bytes)|STRING(string length)|DATA SIZE(8 Bytes)|CRC32C(4 Bytes)|DATA(data size)|
+// Entity format(FeatureOriginSize):
+// |VARINT(1-10 Bytes)|STRING(string length)|DATA SIZE(8 Bytes)|ORIGIN SIZE(8 Bytes)|DATA(data size)|
+// Entity format(FeatureMeta):
+// |VARINT(1-10 Bytes)|STRING(string length)|DATA SIZE(8 Bytes)|VARINT(meta size)|META(meta size)|DATA(data size)|
+// Entity format(FeatureEntryCompress):
+// |VARINT(1-10 Bytes)|STRING(string length)|COMPRESS TYPE(2 Bytes)|DATA SIZE(8 Bytes)|DATA(data size)|
+// 多个特性同时存在时依次为：|COMPRESS TYPE|DATA SIZE|ORIGIN SIZE|CRC32C|VARINT(meta size)|META|DATA|
+// Tombstone entity: DATA SIZE为BlkTombstoneSize且不包含META及DATA，表示删除此前写入的同key实体
 type BlkFileV2 struct {
-	file       BlockReadWriter
-	opener     Opener
-	cur        int64
+	file     BlockReadWriter
+	readerAt io.ReaderAt
+	opener   Opener
+	flag     flags.OpenFlag
+	version  uint16
+	features uint16
+	cur      int64
+	// 第一个实体的位置
+	start      int64
 	header     FileHeader
 	compressor compressor.Compressor
+	// 文件头记录的压缩器（包括字典），读取未记录压缩类型的实体时使用
+	format compressor.Compressor
+	// 根据key选择压缩器（FeatureEntryCompress）
+	selector CompressorSelector
+	// 实体使用的其他压缩类型的压缩器
+	compressors *sync.Map
+
+	// 加密（FeatureEncrypt）：口令或密钥、新建文件使用的加密算法及派生的加密器
+	passphrase []byte
+	secretKey  []byte
+	cipherType Cipher
+	cipher     *payloadCipher
+
+	// 打开时移除或忽略的未提交尾部大小（FeatureCommit）
+	torn int64
+
+	// 签名：V3尾部记录的签名，打开时校验签名使用的公钥及单独保存的签名
+	manifest    *Manifest
+	verifyKey   []byte
+	detachedSig []byte
+
+	// V3格式：尾部索引及其起始位置
+	index      []*blkNode
+	trailerOff int64
+}
+
+// 根据key选择写入实体使用的压缩器，返回nil时使用默认压缩器
+type CompressorSelector func(key string) compressor.Compressor
+
+// 指定扩展名（如".jpg"、".zip"，不区分大小写）的数据不压缩，其他数据使用默认压缩器
+func NoCompressExtSelector(exts ...string) CompressorSelector {
+	m := map[string]bool{}
+	for _, ext := range exts {
+		m[strings.ToLower(ext)] = true
+	}
+	c := compressor.NewBufferCompressor(BlkFileBufferSize)
+	return func(key string) compressor.Compressor {
+		if m[strings.ToLower(path.Ext(key))] {
+			return c
+		}
+		return nil
+	}
 }
 
 func NewBlkFileV2(path string) *BlkFileV2 {
@@ -36,13 +107,16 @@ func NewBlkFileV2(path string) *BlkFileV2 {
 
 func NewBlkFileV2WithOpener(opener Opener) *BlkFileV2 {
 	return &BlkFileV2{
-		opener: opener,
+		opener:  opener,
+		version: BlkFileV2Version,
 		header: FileHeader{
 			Version:    BlkFileV2Version,
 			DataFormat: compressor.TypeNone,
 		},
-		cur:        0,
-		compressor: nil,
+		cur:         0,
+		start:       BlkFileHeadSize,
+		compressor:  nil,
+		compressors: &sync.Map{},
 	}
 }
 
@@ -53,47 +127,136 @@ func (bf *BlkFileV2) WithCompressor(compressor compressor.Compressor) *BlkFileV2
 	return bf
 }
 
+// 新建文件时在每个实体之后写入提交记录，追加写入中断（如进程异常退出）时，
+// 写入模式打开将移除未提交的尾部，只读模式打开时忽略
+func (bf *BlkFileV2) WithCommit() *BlkFileV2 {
+	bf.features |= FeatureCommit
+	return bf
+}
+
+// 新建文件时记录每个实体原始数据的CRC32C校验值，读取时校验
+func (bf *BlkFileV2) WithChecksum() *BlkFileV2 {
+	bf.features |= FeatureChecksum
+	return bf
+}
+
+// 新建文件时记录每个实体的元数据（EntryMeta）
+func (bf *BlkFileV2) WithMeta() *BlkFileV2 {
+	bf.features |= FeatureMeta
+	return bf
+}
+
+// 新建文件时在实体头记录原始数据大小，无需解压即可获得
+func (bf *BlkFileV2) WithOriginSize() *BlkFileV2 {
+	bf.features |= FeatureOriginSize
+	return bf
+}
+
+// 新建文件时在每个实体记录压缩类型，可按实体选择压缩器，追加写入时可使用与文件不同的压缩器
+func (bf *BlkFileV2) WithEntryCompress() *BlkFileV2 {
+	bf.features |= FeatureEntryCompress
+	return bf
+}
+
+// 根据key选择压缩器（如已压缩的jpg、zip不再压缩），文件需包含FeatureEntryCompress
+func (bf *BlkFileV2) WithCompressorSelector(selector CompressorSelector) *BlkFileV2 {
+	bf.selector = selector
+	return bf
+}
+
 func (bf *BlkFileV2) Open(flag flags.OpenFlag) error {
-	if flag.CanWrite() && flag.CanRead() {
-		return jengaerr.OpenRWFlagError.Format("BlockV2")
-	}
 	f, new, err := bf.opener(flag)
 	if err != nil {
 		return err
 	}
+	if _, ok := f.(io.ReaderAt); !ok && flag.CanRead() && flag.CanWrite() {
+		// 读写模式下读取不能移动写入位置
+		_ = f.Close()
+		return jengaerr.NotSupportError.Format("File without io.ReaderAt", "read-write mode")
+	}
 	bf.file = f
+	bf.readerAt = newReaderAt(f)
+	bf.flag = flag
+	bf.header.Version = bf.version
+	bf.index = nil
+	bf.trailerOff = 0
+	bf.compressors = &sync.Map{}
+	bf.cipher = nil
+	bf.manifest = nil
+	bf.torn = 0
 	if !new {
-		if flag.CanRead() {
-			bf.cur = BlkFileHeadSize
+		// 读写模式按写入处理：写入位置为文件末尾，读取使用ReadAt不影响写入位置
+		if flag.CanWrite() {
 			err = bf.readHeader()
-			if err != nil {
-				_ = f.Close()
-				return err
+			if err == nil {
+				err = bf.checkWritable()
 			}
-			return nil
-		} else if flag.CanWrite() {
-			err = bf.readHeader()
 			if err != nil {
 				_ = f.Close()
 				return err
 			}
 			bf.cur, err = bf.file.Seek(0, io.SeekEnd)
+			if err == nil && bf.hasTrailer() {
+				err = bf.loadTrailer()
+			}
+			if err == nil {
+				err = bf.checkSignature()
+			}
 			if err != nil {
 				_ = f.Close()
 			}
 			return err
+		} else if flag.CanRead() {
+			err = bf.readHeader()
+			bf.cur = bf.start
+			if err == nil && bf.hasTrailer() {
+				err = bf.loadTrailer()
+			}
+			if err == nil {
+				err = bf.checkSignature()
+			}
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+			return nil
 		}
 	} else {
 		if flag.NeedCreate() {
-			bf.cur = BlkFileHeadSize
 			if bf.compressor == nil {
 				bf.compressor = compressor.NewBufferCompressor(BlkFileBufferSize)
 			}
+			if err = bf.checkWritable(); err != nil {
+				_ = f.Close()
+				return err
+			}
+			bf.format = bf.compressor
 			bf.header.DataFormat = bf.compressor.Type().Value()
+			bf.header.Reserve = bf.features
+			if _, ok := f.(*streamWriter); ok {
+				bf.header.Reserve |= FeatureStream
+			}
+			if len(compressorDict(bf.compressor)) > 0 {
+				bf.header.Reserve |= FeatureDict
+			}
+			if bf.hasSecret() {
+				bf.header.Reserve |= FeatureEncrypt
+			} else if bf.header.HasFeature(FeatureEncryptKey) {
+				_ = f.Close()
+				return jengaerr.EncryptKeyRequiredError
+			}
+			// 旧版本程序不识别格式特性，包含特性的V2格式文件使用不同的版本号
+			if bf.header.Version == BlkFileV2Version && bf.header.Reserve != 0 {
+				bf.header.Version = BlkFileV2FeatureVersion
+			}
+			if bf.hasTrailer() {
+				bf.index = []*blkNode{}
+			}
 			err = bf.writeHeader(0)
 			if err != nil {
 				_ = f.Close()
 			}
+			bf.cur = bf.start
 			return err
 		}
 	}
@@ -103,50 +266,199 @@ func (bf *BlkFileV2) Open(flag flags.OpenFlag) error {
 
 func (bf *BlkFileV2) Close() error {
 	if bf.file != nil {
-		return bf.file.Close()
+		var err error
+		if bf.flag.CanWrite() && bf.hasTrailer() {
+			err = bf.writeTrailer()
+		}
+		if e := bf.file.Close(); e != nil && err == nil {
+			err = e
+		}
+		return err
 	}
 	return nil
 }
 
-// 12 Bytes
+// 12 Bytes，FeatureDict时之后为|VARINT(dict size)|DICT|，FeatureEncrypt时之后为加密信息
 func (bf *BlkFileV2) writeHeader(size uint64) error {
-	return WriteFileHeader(bf.header, bf.file)
-}
-
-func (bf *BlkFileV2) selectCompressor() error {
-	if bf.compressor == nil {
-		bf.compressor = compressor.NewBufferCompressor(BlkFileBufferSize)
+	bf.start = BlkFileHeadSize
+	err := WriteFileHeader(bf.header, bf.file)
+	if err != nil {
+		return err
 	}
-	cur := bf.compressor.Type().Value()
-	switch bf.header.DataFormat {
-	case compressor.TypeNone:
-		if cur != bf.header.DataFormat {
-			bf.compressor = compressor.NewBufferCompressor(BlkFileBufferSize)
+	if bf.header.HasFeature(FeatureDict) {
+		n, err := writeDict(bf.file, compressorDict(bf.compressor))
+		bf.start += n
+		if err != nil {
+			return err
 		}
-	case compressor.TypeGzip:
-		if cur != bf.header.DataFormat {
-			bf.compressor = compressor.NewGzipCompressor()
+	}
+	if bf.header.HasFeature(FeatureEncrypt) {
+		h, err := bf.newEncryptHeader()
+		if err != nil {
+			return err
 		}
-	case compressor.TypeZlib:
-		if cur != bf.header.DataFormat {
-			bf.compressor = compressor.NewZlibCompressor()
+		n, err := bf.file.Write(h.bytes())
+		bf.start += int64(n)
+		return err
+	}
+	return nil
+}
+
+// 使用文件头记录的压缩类型及字典，与当前压缩器不一致时从注册表（compressor.Register）创建。
+// 写入包含FeatureEntryCompress的文件时，新的实体仍使用当前压缩器，否则当前压缩器需与文件一致
+func (bf *BlkFileV2) loadCompressor(r io.Reader) (int64, error) {
+	c := bf.compressor
+	if c == nil || c.Type().Value() != bf.header.DataFormat {
+		var ok bool
+		c, ok = compressor.NewCompressor(bf.header.DataFormat)
+		if !ok {
+			return 0, jengaerr.DataFormatNotSupportError.Format(bf.header.DataFormat)
 		}
-	default:
-		return jengaerr.DataFormatNotSupportError.Format(bf.header.DataFormat)
+	}
+	n, c, err := bf.readDict(r, c)
+	if err != nil {
+		return n, err
+	}
+	bf.format = c
+	if bf.compressor == nil || bf.compressor.Type() == c.Type() || !bf.flag.CanWrite() {
+		bf.compressor = c
+		return n, nil
+	}
+	name := compressor.GetName(bf.compressor.Type().Value())
+	if !bf.header.HasFeature(FeatureEntryCompress) {
+		return n, jengaerr.NotSupportError.Format("Jenga file without entry-compress feature", "compressor "+name)
+	}
+	if len(compressorDict(bf.compressor)) > 0 {
+		return n, jengaerr.NotSupportError.Format(name+" different from file data format", "dictionary")
+	}
+	return n, nil
+}
+
+// 获得实体压缩类型对应的压缩器，文件头记录的压缩类型使用文件中的字典
+func (bf *BlkFileV2) entryCompressor(t uint16) (compressor.Compressor, error) {
+	if bf.format != nil && bf.format.Type().Value() == t {
+		return bf.format, nil
+	}
+	if bf.compressor != nil && bf.compressor.Type().Value() == t {
+		return bf.compressor, nil
+	}
+	if v, ok := bf.compressors.Load(t); ok {
+		return v.(compressor.Compressor), nil
+	}
+	c, ok := compressor.NewCompressor(t)
+	if !ok {
+		return nil, jengaerr.DataFormatNotSupportError.Format(t)
+	}
+	v, _ := bf.compressors.LoadOrStore(t, c)
+	return v.(compressor.Compressor), nil
+}
+
+// 获得写入key使用的压缩器
+func (bf *BlkFileV2) selectCompressor(key string) (compressor.Compressor, error) {
+	if bf.selector == nil {
+		return bf.compressor, nil
+	}
+	c := bf.selector(key)
+	if c == nil || c.Type() == bf.compressor.Type() {
+		return bf.compressor, nil
+	}
+	name := compressor.GetName(c.Type().Value())
+	if !bf.header.HasFeature(FeatureEntryCompress) {
+		return nil, jengaerr.NotSupportError.Format("Jenga file without entry-compress feature", "compressor "+name)
+	}
+	if len(compressorDict(c)) > 0 {
+		return nil, jengaerr.NotSupportError.Format(name+" different from file data format", "dictionary")
+	}
+	if compressor.IsReadOnly(c) {
+		return nil, jengaerr.CompressorReadOnlyError.Format(name)
+	}
+	// 同一次打开中读取时使用写入的压缩器
+	v, _ := bf.compressors.LoadOrStore(c.Type().Value(), c)
+	return v.(compressor.Compressor), nil
+}
+
+// 只能解压的压缩器（如bzip2）不能写入
+func (bf *BlkFileV2) checkWritable() error {
+	if compressor.IsReadOnly(bf.compressor) {
+		return jengaerr.CompressorReadOnlyError.Format(compressor.GetName(bf.compressor.Type().Value()))
 	}
 	return nil
 }
 
+// 是否为V2格式的版本号（包括包含格式特性的版本）
+func IsV2Version(version uint16) bool {
+	return version == BlkFileV2Version || version == BlkFileV2FeatureVersion
+}
+
 func (bf *BlkFileV2) readHeader() error {
 	h, err := ReadFileHeader(bf.file)
 	if err != nil {
 		return err
 	}
-	if h.Version != bf.header.Version {
-		return jengaerr.VersionNotSupportError.Format(h.Version, bf.header.Version)
+	// V3可兼容读写V2格式文件（不包含尾部索引）
+	if h.Version != bf.version && !IsV2Version(h.Version) {
+		return jengaerr.VersionNotSupportError.Format(h.Version, bf.version)
+	}
+	if err = checkFeatures(h); err != nil {
+		return err
 	}
 	bf.header = h
-	return bf.selectCompressor()
+	n, err := bf.loadCompressor(bf.file)
+	bf.start = BlkFileHeadSize + n
+	if err != nil {
+		return err
+	}
+	n, err = bf.loadCipher(bf.file)
+	bf.start += n
+	return err
+}
+
+// 读取文件头之后的压缩字典并设置至压缩器c，文件不包含字典时压缩器不使用字典
+func (bf *BlkFileV2) readDict(r io.Reader, c compressor.Compressor) (int64, compressor.Compressor, error) {
+	dc, ok := c.(compressor.DictCompressor)
+	if !bf.header.HasFeature(FeatureDict) {
+		if ok && len(dc.Dict()) > 0 {
+			ret, err := dc.WithDict(nil)
+			return 0, ret, err
+		}
+		return 0, c, nil
+	}
+	size, n, err := readVarint(r)
+	if err != nil {
+		return int64(n), nil, err
+	}
+	if size > maxDictSize {
+		return int64(n), nil, jengaerr.JengaBrokenError
+	}
+	dict := make([]byte, size)
+	rn, err := io.ReadFull(r, dict)
+	if err != nil {
+		return int64(n + rn), nil, err
+	}
+	if !ok {
+		return int64(n + rn), nil, jengaerr.NotSupportError.Format(compressor.GetName(bf.header.DataFormat), "dictionary")
+	}
+	ret, err := dc.WithDict(dict)
+	return int64(n + rn), ret, err
+}
+
+func writeDict(w io.Writer, dict []byte) (int64, error) {
+	vi := VarInt{}
+	vi.InitFromUInt64(uint64(len(dict)))
+	n, err := w.Write(vi.Bytes())
+	if err != nil {
+		return int64(n), err
+	}
+	wn, err := w.Write(dict)
+	return int64(n + wn), err
+}
+
+// 获得压缩器使用的字典
+func compressorDict(c compressor.Compressor) []byte {
+	if dc, ok := c.(compressor.DictCompressor); ok {
+		return dc.Dict()
+	}
+	return nil
 }
 
 func (bf *BlkFileV2) WriteFile(path string) (int64, error) {
@@ -172,46 +484,299 @@ func (bf *BlkFileV2) ReadFile(path string) (*BlkHeader, error) {
 }
 
 func (bf *BlkFileV2) WriteBlock(key string, reader io.Reader) (int64, error) {
-	length := len(key)
+	node, err := bf.writeBlock(key, nil, reader)
+	if node == nil {
+		return 0, err
+	}
+	return node.originSize, err
+}
+
+// 写入数据及其元数据，文件需包含FeatureMeta
+func (bf *BlkFileV2) WriteBlockWithMeta(key string, meta EntryMeta, reader io.Reader) (int64, error) {
+	if !bf.header.HasFeature(FeatureMeta) {
+		return 0, jengaerr.NotSupportError.Format("Jenga file without meta feature", "WriteBlockWithMeta")
+	}
+	node, err := bf.writeBlock(key, &meta, reader)
+	if node == nil {
+		return 0, err
+	}
+	return node.originSize, err
+}
+
+// 写入删除标记，读取时将忽略此前写入的同key实体
+func (bf *BlkFileV2) WriteTombstone(key string) error {
+	if bf.isStream() {
+		return bf.writeStreamTombstone(key)
+	}
+	start := bf.cur
+	err := bf.writeKey(key, bf.compressor.Type().Value())
+	if err != nil {
+		return err
+	}
+	wn, err := bf.file.Write(bf.entityHead(BlkTombstoneSize, 0, 0))
+	bf.cur += int64(wn)
+	if err != nil {
+		return err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, &blkNode{
+			key:      key,
+			offset:   bf.cur,
+			deleted:  true,
+			compress: bf.compressor.Type().Value(),
+		})
+	}
+	return nil
+}
+
+// 写入key，包含FeatureEntryCompress时之后为压缩类型
+func (bf *BlkFileV2) writeKey(key string, compress uint16) error {
+	data, err := bf.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxKeySize {
+		return jengaerr.WriteKeySizeError.Format(len(data), maxKeySize)
+	}
+	err = bf.dropTrailer()
+	if err != nil {
+		return err
+	}
 	vi := VarInt{}
-	vi.InitFromUInt64(uint64(length))
+	vi.InitFromUInt64(uint64(len(data)))
 	wn, err := bf.file.Write(vi.Bytes())
 	bf.cur += int64(wn)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	wn, err = bf.file.Write([]byte(key))
+	wn, err = bf.file.Write(data)
 	bf.cur += int64(wn)
-	if err != nil {
+	if err != nil || !bf.header.HasFeature(FeatureEntryCompress) {
+		return err
+	}
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, compress)
+	wn, err = bf.file.Write(buf)
+	bf.cur += int64(wn)
+	return err
+}
+
+// 写入已压缩的数据（如其他工具生成的.bz2文件），数据原样保存，读取时使用compressType对应的压缩器解压。
+// compressType与文件的DATA FORMAT不同时文件需包含FeatureEntryCompress，可使用只能解压的压缩器（如bzip2）
+func (bf *BlkFileV2) WriteRawBlock(key string, compressType compressor.Type, reader io.Reader) (int64, error) {
+	node, err := bf.writeRawData(key, nil, compressType, reader)
+	if node == nil {
 		return 0, err
 	}
+	return node.originSize, err
+}
+
+func (bf *BlkFileV2) writeBlock(key string, meta *EntryMeta, reader io.Reader) (*blkNode, error) {
+	c, err := bf.selectCompressor(key)
+	if err != nil {
+		return nil, err
+	}
+	return bf.writeEntity(key, meta, c, reader, false)
+}
+
+// 写入已压缩的数据，写入时解压获得原始数据大小、校验和及摘要，数据无法解压时返回错误
+func (bf *BlkFileV2) writeRawData(key string, meta *EntryMeta, compressType compressor.Type, reader io.Reader) (*blkNode, error) {
+	if compressType.Value() != bf.header.DataFormat && !bf.header.HasFeature(FeatureEntryCompress) {
+		name := compressor.GetName(compressType.Value())
+		return nil, jengaerr.NotSupportError.Format("Jenga file without entry-compress feature", "compressor "+name)
+	}
+	c, err := bf.entryCompressor(compressType.Value())
+	if err != nil {
+		return nil, err
+	}
+	return bf.writeEntity(key, meta, c, reader, true)
+}
+
+// 使用压缩器c写入实体，raw为true时reader为c压缩后的数据
+func (bf *BlkFileV2) writeEntity(key string, meta *EntryMeta, c compressor.Compressor, reader io.Reader, raw bool) (*blkNode, error) {
+	if bf.isStream() {
+		return bf.writeStreamBlock(key, meta, c, reader, raw)
+	}
+	start := bf.cur
+	err := bf.writeKey(key, c.Type().Value())
+	if err != nil {
+		return nil, err
+	}
 	cur := bf.cur
+	headSize := bf.entityHeadSize()
 	// write data first,get data length
-	_, err = bf.file.Seek(8, io.SeekCurrent)
-	bf.cur += 8
+	_, err = bf.file.Seek(headSize, io.SeekCurrent)
+	bf.cur += headSize
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if bf.header.HasFeature(FeatureMeta) {
+		n, err := writeEntryMeta(bf.file, meta)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		meta = nil
 	}
+	node := &blkNode{
+		key:      key,
+		offset:   bf.cur,
+		meta:     meta,
+		compress: c.Type().Value(),
+	}
+	c, reader, sum := bf.hashEntity(c, reader, raw)
 	// write data
-	originWn, n, err := bf.compressor.Compress(bf.file, reader)
+	originWn, n, err := bf.compressTo(bf.file, c, reader, key)
 	bf.cur += n
+	node.size = n
+	node.originSize = originWn
 	if err != nil {
-		return originWn, err
+		return node, err
 	}
 	// seek to size record position
 	_, err = bf.file.Seek(cur, io.SeekStart)
 	if err != nil {
-		return originWn, err
+		return node, err
 	}
-	buf := make([]byte, 8)
-	binary.BigEndian.PutUint64(buf, uint64(n))
-	_, err = bf.file.Write(buf)
+	sum(node)
+	_, err = bf.file.Write(bf.entityHead(uint64(n), originWn, node.checksum))
 	if err != nil {
-		return originWn, err
+		return node, err
 	}
 	// seek to end
 	_, err = bf.file.Seek(bf.cur, io.SeekStart)
-	return originWn, err
+	if err != nil {
+		return node, err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return node, err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, node)
+	}
+	return node, nil
+}
+
+// 计算原始数据的校验和（FeatureChecksum）及摘要（尾部索引），返回的sum将结果设置至实体。
+// raw为true时reader为已压缩的数据，返回的压缩器原样写入数据并解压计算
+func (bf *BlkFileV2) hashEntity(c compressor.Compressor, reader io.Reader, raw bool) (compressor.Compressor, io.Reader, func(node *blkNode)) {
+	var ws []io.Writer
+	var h hash.Hash32
+	if bf.header.HasFeature(FeatureChecksum) {
+		h = crc32.New(castagnoliTable)
+		ws = append(ws, h)
+	}
+	var dh hash.Hash
+	if bf.hasTrailer() {
+		dh = sha256.New()
+		ws = append(ws, dh)
+	}
+	sum := func(node *blkNode) {
+		if h != nil {
+			node.checksum = h.Sum32()
+		}
+		if dh != nil {
+			node.digest = dh.Sum(nil)
+		}
+	}
+	if raw {
+		return &rawCompressor{Compressor: c, w: io.MultiWriter(ws...)}, reader, sum
+	}
+	if len(ws) > 0 {
+		reader = io.TeeReader(reader, io.MultiWriter(ws...))
+	}
+	return c, reader, sum
+}
+
+// 原样写入已压缩的数据，同时解压至w获得原始数据大小
+type rawCompressor struct {
+	compressor.Compressor
+	w io.Writer
+}
+
+func (c *rawCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (int64, int64, error) {
+	sw := compressor.NewSizeWriter(dstWriter)
+	_, before, err := c.Decompress(c.w, io.TeeReader(srcReader, sw))
+	if err == nil {
+		// 压缩数据之后未被解压器读取的部分同样写入
+		_, err = io.Copy(sw, srcReader)
+	}
+	return before, sw.Size(), err
+}
+
+// 将src中node的数据（已压缩）原样写入，要求可读取node的压缩类型（canWriteRaw）
+func (bf *BlkFileV2) writeRawBlock(src *BlkFileV2, node *blkNode) (*blkNode, error) {
+	if bf.isStream() {
+		return bf.writeStreamRawBlock(src, node)
+	}
+	node, err := bf.rawNode(src, node)
+	if err != nil {
+		return nil, err
+	}
+	start := bf.cur
+	err = bf.writeKey(node.key, node.compress)
+	if err != nil {
+		return nil, err
+	}
+	wn, err := bf.file.Write(bf.entityHead(uint64(node.size), node.originSize, node.checksum))
+	bf.cur += int64(wn)
+	if err != nil {
+		return nil, err
+	}
+	var meta *EntryMeta
+	if bf.header.HasFeature(FeatureMeta) {
+		meta = node.meta
+		n, err := writeEntryMeta(bf.file, meta)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+	}
+	ret := &blkNode{
+		key:        node.key,
+		size:       node.size,
+		originSize: node.originSize,
+		offset:     bf.cur,
+		checksum:   node.checksum,
+		meta:       meta,
+		compress:   node.compress,
+		digest:     node.digest,
+	}
+	n, err := io.Copy(bf.file, src.payload(node))
+	bf.cur += n
+	if err != nil {
+		return ret, err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return ret, err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, ret)
+	}
+	return ret, nil
+}
+
+// 源文件未记录原始大小（不包含FeatureOriginSize）而目标文件包含时，解压数据获得原始大小并同时计算摘要
+func (bf *BlkFileV2) rawNode(src *BlkFileV2, node *blkNode) (*blkNode, error) {
+	if node.originSize != BlkHeaderUnknownSize || !bf.header.HasFeature(FeatureOriginSize) {
+		return node, nil
+	}
+	h := sha256.New()
+	_, originSize, err := src.decompressFrom(h, src.payload(node), node)
+	if err != nil {
+		return nil, err
+	}
+	ret := *node
+	ret.originSize = originSize
+	ret.digest = h.Sum(nil)
+	return &ret, nil
 }
 
 func (bf *BlkFileV2) seek(offset int64) error {
@@ -223,14 +788,19 @@ func (bf *BlkFileV2) seek(offset int64) error {
 	return nil
 }
 
+// 顺序读取，读写模式下不支持（写入位置与读取位置共用）
 func (bf *BlkFileV2) ReadBlock(w io.Writer) (*BlkHeader, error) {
+	if bf.flag.CanWrite() && bf.flag.CanRead() {
+		return nil, jengaerr.NotSupportError.Format("Read-write mode", "ReadBlock")
+	}
 	n, err := bf.readBlock(w)
 	if err != nil {
 		return nil, err
 	} else {
 		return &BlkHeader{
-			Key:  n.key,
-			Size: n.originSize,
+			Key:     n.key,
+			Size:    n.readSize(),
+			Deleted: n.deleted,
 		}, nil
 	}
 }
@@ -246,6 +816,9 @@ func (bf *BlkFileV2) readKey() (string, error) {
 		return "", jengaerr.ReadBlockVarintFailedError
 	}
 	size := vi.ToUint()
+	if size > maxKeySize {
+		return "", jengaerr.JengaBrokenError
+	}
 	buf := make([]byte, size)
 	rn, err = bf.file.Read(buf)
 	bf.cur += int64(rn)
@@ -255,7 +828,7 @@ func (bf *BlkFileV2) readKey() (string, error) {
 	if rn != int(size) {
 		return "", jengaerr.ReadKeySizeNotMatchError
 	}
-	return string(buf), nil
+	return bf.decodeKey(buf)
 }
 
 func (bf *BlkFileV2) readPayloadSize() (int64, error) {
@@ -268,27 +841,150 @@ func (bf *BlkFileV2) readPayloadSize() (int64, error) {
 	return int64(binary.BigEndian.Uint64(buf)), nil
 }
 
-func (bf *BlkFileV2) readPayload(w io.Writer, size int64) (int64, error) {
+// 读取实体的压缩类型，未包含FeatureEntryCompress时为文件头的DATA FORMAT
+func (bf *BlkFileV2) readCompressType() (uint16, error) {
+	if !bf.header.HasFeature(FeatureEntryCompress) {
+		return bf.header.DataFormat, nil
+	}
+	buf := make([]byte, 2)
+	rn, err := bf.file.Read(buf)
+	bf.cur += int64(rn)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf), nil
+}
+
+// 实体能否原样写入：node的压缩类型、字典及加密密钥与写入时一致
+func (bf *BlkFileV2) canWriteRaw(src *BlkFileV2, node *blkNode) bool {
+	if node.compress != bf.compressor.Type().Value() && !bf.header.HasFeature(FeatureEntryCompress) {
+		return false
+	}
+	sc, err := src.entryCompressor(node.compress)
+	if err != nil {
+		return false
+	}
+	dc, err := bf.entryCompressor(node.compress)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(compressorDict(sc), compressorDict(dc)) && sameCipher(src.cipher, bf.cipher)
+}
+
+func (bf *BlkFileV2) readChecksum() (uint32, error) {
+	buf := make([]byte, 4)
+	rn, err := bf.file.Read(buf)
+	bf.cur += int64(rn)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf), nil
+}
+
+func (bf *BlkFileV2) readPayload(w io.Writer, node *blkNode) (int64, error) {
 	var n, originSize int64
 	var err error
 	if w != nil {
-		r := io.LimitReader(bf.file, size)
-		n, originSize, err = bf.compressor.Decompress(w, r)
+		n, originSize, err = bf.decompress(w, node)
 	} else {
-		n, err = bf.file.Seek(size, io.SeekCurrent)
+		n, err = bf.file.Seek(node.size, io.SeekCurrent)
 		n = n - bf.cur
+		// 跳过数据时无法获得压缩数据的原始大小
+		originSize = BlkHeaderUnknownSize
+		if node.compress == compressor.TypeNone && bf.cipher == nil {
+			originSize = n
+		}
 	}
 	bf.cur += n
 	if err != nil {
 		return -1, err
 	}
-	if n != size {
+	if n != node.size {
 		return -1, jengaerr.ReadNodeSizeNotMatchError
 	}
 	return originSize, err
 }
 
+// 从当前位置读取node数据并解压至w，如包含校验值则校验原始数据
+func (bf *BlkFileV2) decompress(w io.Writer, node *blkNode) (int64, int64, error) {
+	return bf.decompressFrom(w, io.LimitReader(bf.file, node.size), node)
+}
+
+// 从r读取node数据并解压至w，如包含校验值则校验原始数据
+// 返回读取的数据大小及解压后的数据大小
+func (bf *BlkFileV2) decompressFrom(w io.Writer, r io.Reader, node *blkNode) (int64, int64, error) {
+	c, err := bf.entryCompressor(node.compress)
+	if err != nil {
+		return 0, 0, err
+	}
+	var h hash.Hash32
+	if bf.header.HasFeature(FeatureChecksum) {
+		h = crc32.New(castagnoliTable)
+		w = io.MultiWriter(w, h)
+	}
+	var or *openReader
+	if bf.cipher != nil {
+		or = bf.cipher.newReader(r, node.key)
+		r = or
+	}
+	n, originSize, err := c.Decompress(w, r)
+	if or != nil {
+		n, err = or.finish(err)
+	}
+	if err == nil && h != nil && h.Sum32() != node.checksum {
+		err = jengaerr.ReadChecksumNotMatchError.Format(node.key)
+	}
+	return n, originSize, err
+}
+
+// 获得node压缩数据的reader，不影响当前读写位置
+func (bf *BlkFileV2) section(node *blkNode) *io.SectionReader {
+	return io.NewSectionReader(bf.readerAt, node.offset, node.size)
+}
+
+func (bf *BlkFileV2) entityHeadSize() int64 {
+	var size int64 = 8
+	if bf.header.HasFeature(FeatureOriginSize) {
+		size += 8
+	}
+	if bf.header.HasFeature(FeatureChecksum) {
+		size += 4
+	}
+	return size
+}
+
+// |DATA SIZE(8 Bytes)|[ORIGIN SIZE(8 Bytes)]|[CRC32C(4 Bytes)]|
+func (bf *BlkFileV2) entityHead(size uint64, originSize int64, checksum uint32) []byte {
+	buf := make([]byte, bf.entityHeadSize())
+	binary.BigEndian.PutUint64(buf, size)
+	i := 8
+	if bf.header.HasFeature(FeatureOriginSize) {
+		binary.BigEndian.PutUint64(buf[i:], uint64(originSize))
+		i += 8
+	}
+	if bf.header.HasFeature(FeatureChecksum) {
+		binary.BigEndian.PutUint32(buf[i:], checksum)
+	}
+	return buf
+}
+
 func (bf *BlkFileV2) readBlock(w io.Writer) (*blkNode, error) {
+	// V3格式：实体之后为尾部索引
+	if bf.trailerOff > 0 && bf.cur >= bf.trailerOff {
+		return nil, io.EOF
+	}
+	start := bf.cur
+	node, err := bf.readEntity(w)
+	if err == nil {
+		err = bf.readCommit(start)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (bf *BlkFileV2) readEntity(w io.Writer) (*blkNode, error) {
 	node := &blkNode{}
 
 	key, err := bf.readKey()
@@ -296,6 +992,13 @@ func (bf *BlkFileV2) readBlock(w io.Writer) (*blkNode, error) {
 		return nil, err
 	}
 	node.key = key
+	node.compress, err = bf.readCompressType()
+	if err != nil {
+		return nil, err
+	}
+	if bf.isStream() {
+		return bf.readStreamBlock(w, node)
+	}
 
 	size, err := bf.readPayloadSize()
 	if err != nil {
@@ -303,11 +1006,41 @@ func (bf *BlkFileV2) readBlock(w io.Writer) (*blkNode, error) {
 	}
 
 	node.size = size
+	if bf.header.HasFeature(FeatureOriginSize) {
+		node.originSize, err = bf.readPayloadSize()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bf.header.HasFeature(FeatureChecksum) {
+		node.checksum, err = bf.readChecksum()
+		if err != nil {
+			return nil, err
+		}
+	}
 	node.offset = bf.current()
-	node.originSize, err = bf.readPayload(w, size)
+	if uint64(node.size) == BlkTombstoneSize {
+		node.size = 0
+		node.deleted = true
+		return node, nil
+	}
+	if bf.header.HasFeature(FeatureMeta) {
+		meta, n, err := readEntryMeta(bf.file)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+		node.meta = meta
+		node.offset = bf.current()
+	}
+	originSize, err := bf.readPayload(w, node)
 	if err != nil {
 		return nil, err
 	}
+	// 跳过数据时使用实体头记录的原始大小
+	if w != nil || !bf.header.HasFeature(FeatureOriginSize) {
+		node.originSize = originSize
+	}
 
 	return node, nil
 }
@@ -326,17 +1059,14 @@ var BlkFileV2Openers blkFileV2Openers
 
 func (o blkFileV2Openers) Local(path string) Opener {
 	return func(flag flags.OpenFlag) (BlockReadWriter, bool, error) {
-		if flag.CanWrite() && flag.CanRead() {
-			return nil, false, jengaerr.OpenRWFlagError.Format("File")
-		}
 		_, err := os.Stat(path)
 		if err == nil {
-			if flag.CanRead() {
-				f, err := os.Open(path)
-				return f, false, err
-			} else if flag.CanWrite() {
+			if flag.CanWrite() {
 				f, err := os.OpenFile(path, os.O_RDWR, 0666)
 				return f, false, err
+			} else if flag.CanRead() {
+				f, err := os.Open(path)
+				return f, false, err
 			}
 		} else {
 			if flag.NeedCreate() {
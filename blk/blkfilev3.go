@@ -0,0 +1,460 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/xfali/jenga/jengaerr"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	BlkFileV3Version uint16 = 0x0003
+
+	BlkFileV3FooterMagic uint32 = 0x58466AFC
+	BlkFileV3FooterSize         = 16
+	// 尾部起始标记，按VARINT解析时大于maxKeySize，不会与实体的开头相同
+	BlkFileV3TrailerMagic     uint32 = 0xD8C6EA7E
+	BlkFileV3TrailerMagicSize        = 4
+
+	trailerSectionIndex byte = 1
+	// 签名清单（Manifest）
+	trailerSectionSignature byte = 2
+	// 实体原始数据的摘要（Merkle树的叶子）
+	trailerSectionMerkle byte = 3
+)
+
+// File format:
+// |MAGIC NUNMBER(4 Bytes)|VERSION(2 Bytes)|DATA FORMAT(2 Bytes)|REVERSE(2 Bytes)|ENTITY_1|ENTITY_2|...|ENTITY_N|TRAILER|FOOTER|
+// Entity format(same as V2，新建文件默认包含FeatureOriginSize、FeatureEntryCompress及FeatureCommit):
+// |VARINT(1-10 Bytes)|STRING(string length)|DATA SIZE(8 Bytes)|ORIGIN SIZE(8 Bytes)|DATA(data size)|COMMIT(12 Bytes)|
+// Trailer format:
+// |TRAILER MAGIC(4 Bytes)|SECTION TYPE(1 Byte)|VARINT(1-10 Bytes)|SECTION DATA(data size)|...
+// Index section format(仅包含每个key最后写入且未删除的实体):
+// |VARINT(count)|VARINT(key length)|KEY|VARINT(offset)|VARINT(data size)|VARINT(origin size)|[VARINT(compress type)]|[CRC32C(4 Bytes)]|[VARINT(meta size)|META]|...
+// Index section format(FeatureEncryptKey): |NONCE(12 Bytes)|INDEX(encrypted)|TAG(16 Bytes)|
+// Merkle section format: |VARINT(count)|SHA-256(origin data)|...，与索引的顺序相同（可选，数据已加密时加密保存）
+// Signature section format: Manifest（可选）
+// Footer format:
+// |TRAILER OFFSET(8 Bytes)|TRAILER CRC32(4 Bytes)|FOOTER MAGIC(4 Bytes)|
+// TRAILER OFFSET为尾部起始标记的位置，TRAILER CRC32包含起始标记。
+//
+// 索引在Close时写入，只读打开时只需一次Seek即可加载全部索引；
+// footer缺失（如写入过程中进程异常退出）时退化为全量扫描，扫描至尾部起始标记时结束。
+// 顺序读取（Scanner）时通过起始标记识别尾部，无需缓存尾部数据。
+type BlkFileV3 struct {
+	*BlkFileV2
+}
+
+func NewBlkFileV3(path string) *BlkFileV3 {
+	return NewBlkFileV3WithOpener(BlkFileV2Openers.Local(path))
+}
+
+func NewBlkFileV3WithOpener(opener Opener) *BlkFileV3 {
+	f := NewBlkFileV2WithOpener(opener)
+	f.version = BlkFileV3Version
+	f.features |= FeatureOriginSize | FeatureEntryCompress | FeatureCommit
+	return &BlkFileV3{
+		BlkFileV2: f,
+	}
+}
+
+func (bf *BlkFileV2) hasTrailer() bool {
+	return bf.header.Version == BlkFileV3Version
+}
+
+func (bf *BlkFileV2) loadTrailer() error {
+	end, err := bf.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	offset, found, err := bf.readFooter(end)
+	if err != nil {
+		return err
+	}
+	if found {
+		bf.trailerOff = offset
+		index, err := bf.readTrailer(offset, end-BlkFileV3FooterSize)
+		if err == nil {
+			bf.index = index
+		}
+	}
+	if bf.index == nil {
+		// 尾部索引缺失或已损坏，全量扫描重建
+		err = bf.rebuildIndex()
+		if err != nil {
+			return err
+		}
+	}
+	if bf.flag.CanWrite() {
+		// 保留旧的尾部索引，第一次追加写入或Close时移除（dropTrailer）
+		if bf.trailerOff > 0 {
+			return bf.seek(bf.trailerOff)
+		}
+		bf.cur, err = bf.file.Seek(0, io.SeekEnd)
+		return err
+	}
+	return bf.seek(bf.start)
+}
+
+// 写入模式下移除旧的尾部索引，写入位置为尾部索引原来的位置
+func (bf *BlkFileV2) dropTrailer() error {
+	if !bf.flag.CanWrite() || bf.trailerOff <= 0 {
+		return nil
+	}
+	err := bf.truncate(bf.trailerOff)
+	if err != nil {
+		return err
+	}
+	bf.trailerOff = 0
+	return bf.seek(bf.cur)
+}
+
+func (bf *BlkFileV2) readFooter(end int64) (int64, bool, error) {
+	if end < bf.start+BlkFileV3FooterSize {
+		return 0, false, nil
+	}
+	_, err := bf.file.Seek(end-BlkFileV3FooterSize, io.SeekStart)
+	if err != nil {
+		return 0, false, err
+	}
+	buf := make([]byte, BlkFileV3FooterSize)
+	_, err = io.ReadFull(bf.file, buf)
+	if err != nil {
+		return 0, false, err
+	}
+	if binary.BigEndian.Uint32(buf[12:]) != BlkFileV3FooterMagic {
+		return 0, false, nil
+	}
+	offset := int64(binary.BigEndian.Uint64(buf))
+	if offset < bf.start || offset > end-BlkFileV3FooterSize {
+		return 0, false, nil
+	}
+	return offset, true, nil
+}
+
+func (bf *BlkFileV2) readTrailer(offset, end int64) ([]*blkNode, error) {
+	_, err := bf.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, end-offset)
+	_, err = io.ReadFull(bf.file, buf)
+	if err != nil {
+		return nil, err
+	}
+	footer := make([]byte, BlkFileV3FooterSize)
+	_, err = io.ReadFull(bf.file, footer)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(buf) != binary.BigEndian.Uint32(footer[8:]) || !isTrailerMagic(buf) {
+		return nil, jengaerr.JengaBrokenError
+	}
+	var index []*blkNode
+	var manifest *Manifest
+	r := bytes.NewReader(buf[BlkFileV3TrailerMagicSize:])
+	for r.Len() > 0 {
+		t, data, err := readSection(r)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略未知类型的section
+		switch t {
+		case trailerSectionIndex:
+			index, err = bf.decodeIndex(data)
+		case trailerSectionMerkle:
+			if index == nil {
+				return nil, jengaerr.JengaBrokenError
+			}
+			err = bf.decodeDigests(data, index)
+		case trailerSectionSignature:
+			manifest, err = ParseManifest(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if index == nil {
+		return nil, jengaerr.JengaBrokenError
+	}
+	bf.manifest = manifest
+	return index, nil
+}
+
+func (bf *BlkFileV2) rebuildIndex() error {
+	end, err := bf.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	err = bf.seek(bf.start)
+	if err != nil {
+		return err
+	}
+	index := []*blkNode{}
+	for bf.cur < end {
+		if bf.atTrailer() {
+			// footer缺失或尾部已损坏：起始标记之后不再包含实体
+			bf.trailerOff = bf.cur
+			break
+		}
+		start := bf.cur
+		n, err := bf.readBlock(nil)
+		if err != nil {
+			err = bf.tornTail(start, err)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		index = append(index, n)
+	}
+	bf.index = index
+	return nil
+}
+
+// 当前位置是否为尾部起始标记
+func (bf *BlkFileV2) atTrailer() bool {
+	buf := make([]byte, BlkFileV3TrailerMagicSize)
+	n, _ := bf.readerAt.ReadAt(buf, bf.cur)
+	return isTrailerMagic(buf[:n])
+}
+
+func isTrailerMagic(b []byte) bool {
+	return len(b) >= BlkFileV3TrailerMagicSize && binary.BigEndian.Uint32(b) == BlkFileV3TrailerMagic
+}
+
+func (bf *BlkFileV2) writeTrailer() error {
+	err := bf.dropTrailer()
+	if err != nil {
+		return err
+	}
+	err = bf.seek(bf.cur)
+	if err != nil {
+		return err
+	}
+	index, err := bf.encodeIndex()
+	if err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(nil)
+	magic := make([]byte, BlkFileV3TrailerMagicSize)
+	binary.BigEndian.PutUint32(magic, BlkFileV3TrailerMagic)
+	buf.Write(magic)
+	err = writeSection(buf, trailerSectionIndex, index)
+	if err != nil {
+		return err
+	}
+	// 仅在全部实体已有摘要（尾部已记录、本次写入或已计算Merkle根）时写入Merkle section，Close时不解压数据计算
+	live := bf.liveIndex()
+	if hasDigests(live) {
+		digests, err := bf.encodeDigests(live)
+		if err != nil {
+			return err
+		}
+		err = writeSection(buf, trailerSectionMerkle, digests)
+		if err != nil {
+			return err
+		}
+	}
+	// 签名之后写入了数据时签名失效
+	if bf.manifest != nil && bf.manifest.Size == bf.cur {
+		err = writeSection(buf, trailerSectionSignature, bf.manifest.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	footer := make([]byte, BlkFileV3FooterSize)
+	binary.BigEndian.PutUint64(footer, uint64(bf.cur))
+	binary.BigEndian.PutUint32(footer[8:], crc32.ChecksumIEEE(buf.Bytes()))
+	binary.BigEndian.PutUint32(footer[12:], BlkFileV3FooterMagic)
+	buf.Write(footer)
+
+	n, err := bf.file.Write(buf.Bytes())
+	bf.cur += int64(n)
+	return err
+}
+
+type truncater interface {
+	Truncate(size int64) error
+}
+
+func (bf *BlkFileV2) truncate(size int64) error {
+	if t, ok := bf.file.(truncater); ok {
+		return t.Truncate(size)
+	}
+	return jengaerr.FileTruncateError
+}
+
+func (bf *BlkFileV2) encodeIndex() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	crc := make([]byte, 4)
+	index := bf.liveIndex()
+	_ = writeVarint(buf, uint64(len(index)))
+	for _, n := range index {
+		_ = writeVarint(buf, uint64(len(n.key)))
+		buf.WriteString(n.key)
+		_ = writeVarint(buf, uint64(n.offset))
+		_ = writeVarint(buf, uint64(n.size))
+		_ = writeVarint(buf, uint64(n.originSize))
+		if bf.header.HasFeature(FeatureEntryCompress) {
+			_ = writeVarint(buf, uint64(n.compress))
+		}
+		if bf.header.HasFeature(FeatureChecksum) {
+			binary.BigEndian.PutUint32(crc, n.checksum)
+			buf.Write(crc)
+		}
+		if bf.header.HasFeature(FeatureMeta) {
+			_, _ = writeEntryMeta(buf, n.meta)
+		}
+	}
+	if bf.header.HasFeature(FeatureEncryptKey) {
+		return bf.cipher.sealName(buf.Bytes(), []byte{trailerSectionIndex})
+	}
+	return buf.Bytes(), nil
+}
+
+// 按写入顺序保留每个key最后写入的实体，并移除已删除的实体
+func (bf *BlkFileV2) liveIndex() []*blkNode {
+	seen := map[string]bool{}
+	var ret []*blkNode
+	for i := len(bf.index) - 1; i >= 0; i-- {
+		n := bf.index[i]
+		if seen[n.key] {
+			continue
+		}
+		seen[n.key] = true
+		if !n.deleted {
+			ret = append(ret, n)
+		}
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return ret
+}
+
+func (bf *BlkFileV2) decodeIndex(data []byte) ([]*blkNode, error) {
+	if bf.header.HasFeature(FeatureEncryptKey) {
+		var err error
+		data, err = bf.cipher.openName(data, []byte{trailerSectionIndex})
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := bytes.NewReader(data)
+	count, _, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// 每个实体的索引至少包含1个字节，count超出剩余数据时索引已损坏
+	if count > uint64(r.Len()) {
+		return nil, jengaerr.JengaBrokenError
+	}
+	index := make([]*blkNode, 0, count)
+	for i := uint64(0); i < count; i++ {
+		n := &blkNode{}
+		size, _, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(r.Len()) {
+			return nil, jengaerr.ReadKeySizeNotMatchError
+		}
+		key := make([]byte, size)
+		_, _ = r.Read(key)
+		n.key = string(key)
+		v, _, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		n.offset = int64(v)
+		if v, _, err = readVarint(r); err != nil {
+			return nil, err
+		}
+		n.size = int64(v)
+		if v, _, err = readVarint(r); err != nil {
+			return nil, err
+		}
+		n.originSize = int64(v)
+		n.compress = bf.header.DataFormat
+		if bf.header.HasFeature(FeatureEntryCompress) {
+			if v, _, err = readVarint(r); err != nil {
+				return nil, err
+			}
+			n.compress = uint16(v)
+		}
+		if bf.header.HasFeature(FeatureChecksum) {
+			crc := make([]byte, 4)
+			if _, err = io.ReadFull(r, crc); err != nil {
+				return nil, err
+			}
+			n.checksum = binary.BigEndian.Uint32(crc)
+		}
+		if bf.header.HasFeature(FeatureMeta) {
+			if n.meta, _, err = readEntryMeta(r); err != nil {
+				return nil, err
+			}
+		}
+		index = append(index, n)
+	}
+	if r.Len() > 0 {
+		return nil, jengaerr.JengaBrokenError
+	}
+	return index, nil
+}
+
+func writeSection(w io.Writer, t byte, data []byte) error {
+	_, err := w.Write([]byte{t})
+	if err != nil {
+		return err
+	}
+	err = writeVarint(w, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readSection(r *bytes.Reader) (byte, []byte, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, _, err := readVarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > uint64(r.Len()) {
+		return 0, nil, jengaerr.JengaBrokenError
+	}
+	data := make([]byte, size)
+	_, _ = r.Read(data)
+	return t, data, nil
+}
+
+func writeVarint(w io.Writer, x uint64) error {
+	vi := VarInt{}
+	vi.InitFromUInt64(x)
+	_, err := w.Write(vi.Bytes())
+	return err
+}
+
+func readVarint(r io.Reader) (uint64, int, error) {
+	vi := VarInt{}
+	b, n, err := vi.LoadFromReader(r)
+	if err != nil {
+		return 0, n, err
+	}
+	if !b {
+		return 0, n, jengaerr.ReadBlockVarintFailedError
+	}
+	return vi.ToUint(), n, nil
+}
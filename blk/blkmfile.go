@@ -7,6 +7,7 @@ package jengablk
 
 import (
 	"errors"
+	"github.com/xfali/jenga/compressor"
 	"github.com/xfali/jenga/flags"
 	"github.com/xfali/jenga/jengaerr"
 	"io"
@@ -119,6 +120,14 @@ func (bf *blockV1) WriteBlock(key string, reader io.Reader) (int64, error) {
 	}
 }
 
+func (bf *blockV1) WriteBlockWithMeta(key string, meta EntryMeta, reader io.Reader) (int64, error) {
+	return 0, jengaerr.NotSupportError.Format("BlockV1", "WriteBlockWithMeta")
+}
+
+func (bf *blockV1) DeleteBlock(key string) error {
+	return jengaerr.NotSupportError.Format("BlockV1", "DeleteBlock")
+}
+
 func (bf *blockV1) NeedSize() bool {
 	return bf.sizeFunc == nil
 }
@@ -136,25 +145,51 @@ func (bf *blockV1) Keys() []string {
 	return ret
 }
 
+func (bf *blockV1) OpenBlock(key string) (io.ReadCloser, error) {
+	r, err := bf.OpenBlockSection(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sectionReadCloser{r}, nil
+}
+
+func (bf *blockV1) StatBlock(key string) (EntryInfo, error) {
+	if v, ok := bf.meta.Load(key); ok {
+		node := v.(*blkNode)
+		if !node.invalid() {
+			return EntryInfo{
+				Key:        key,
+				Size:       node.size,
+				OriginSize: node.size,
+				Offset:     node.offset,
+				Compress:   compressor.TypeNone,
+			}, nil
+		}
+	}
+	return EntryInfo{}, jengaerr.ReadKeyNotFoundError.Format(key)
+}
+
+func (bf *blockV1) OpenBlockSection(key string) (*io.SectionReader, error) {
+	if v, ok := bf.meta.Load(key); ok {
+		node := v.(*blkNode)
+		if !node.invalid() {
+			return io.NewSectionReader(bf.f.readerAt, node.offset, node.size), nil
+		}
+	}
+	return nil, jengaerr.ReadKeyNotFoundError.Format(key)
+}
+
 func (bf *blockV1) ReadBlockByKey(key string, w io.Writer) (int64, error) {
 	if v, ok := bf.meta.Load(key); ok {
 		node := v.(*blkNode)
 		if node.invalid() {
 			return 0, jengaerr.ReadKeyNotFoundError.Format(key)
 		}
-		err := bf.f.seek(node.offset)
-		if err != nil {
-			return 0, err
-		}
-		var n int64
-		if w != nil {
-			r := io.LimitReader(bf.f.file, node.size)
-			n, err = io.CopyBuffer(w, r, bf.f.buf)
-		} else {
-			n, err = bf.f.file.Seek(node.size, io.SeekCurrent)
-			n = n - bf.f.cur
+		if w == nil {
+			return node.size, nil
 		}
-		bf.f.cur += n
+		// 使用ReadAt读取，可并发调用
+		n, err := io.Copy(w, io.NewSectionReader(bf.f.readerAt, node.offset, node.size))
 		if err != nil {
 			return n, err
 		}
@@ -167,6 +202,17 @@ func (bf *blockV1) ReadBlockByKey(key string, w io.Writer) (int64, error) {
 	}
 }
 
+// 将全部数据写入dst（需以OpFlagWriteOnly打开）
+func (bf *blockV1) Compact(dst JengaBlocks) error {
+	for _, key := range bf.Keys() {
+		err := copyBlock(bf, dst, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (bf *blockV1) Flush() error {
 	return bf.f.Flush()
 }
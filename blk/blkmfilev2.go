@@ -6,6 +6,7 @@
 package jengablk
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"github.com/xfali/jenga/compressor"
 	"github.com/xfali/jenga/flags"
@@ -13,13 +14,18 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
 	"sync"
 )
 
+// 读取（ReadBlockByKey、OpenBlock、OpenBlockSection）使用ReadAt，可并发调用；
+// 写入之间使用lock互斥。
 type blockV2 struct {
-	f      *BlkFileV2
-	filter KeyFilter
-	meta   sync.Map
+	f         *BlkFileV2
+	filter    KeyFilter
+	overwrite bool
+	meta      sync.Map
+	lock      sync.Mutex
 }
 
 type BlocksV2Opt func(f *blockV2)
@@ -31,6 +37,30 @@ func NewV2BlockFile(path string, opts ...BlocksV2Opt) *blockV2 {
 	return NewV2Blocks(append(newOpt, opts...)...)
 }
 
+func NewV3BlockFile(path string, opts ...BlocksV2Opt) *blockV2 {
+	newOpt := []BlocksV2Opt{BlockV2Opts.LocalFile(path)}
+	return NewV3Blocks(append(newOpt, opts...)...)
+}
+
+func NewV3Blocks(opts ...BlocksV2Opt) *blockV2 {
+	ret := NewV2Blocks(opts...)
+	ret.f.version = BlkFileV3Version
+	ret.f.features |= FeatureOriginSize | FeatureEntryCompress | FeatureCommit
+	return ret
+}
+
+// 创建后追加选项（如jenga.WithPassphrase），blocks不为V2、V3格式时返回false
+func ApplyBlocksV2Opts(blocks JengaBlocks, opts ...BlocksV2Opt) bool {
+	b, ok := blocks.(*blockV2)
+	if !ok {
+		return false
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return true
+}
+
 func NewV2Blocks(opts ...BlocksV2Opt) *blockV2 {
 	ret := &blockV2{}
 	for _, opt := range opts {
@@ -42,10 +72,8 @@ func NewV2Blocks(opts ...BlocksV2Opt) *blockV2 {
 	return ret
 }
 
+// 支持读写模式（OpFlagReadOnly|OpFlagWriteOnly），写入的数据立即可读
 func (bf *blockV2) Open(flag flags.OpenFlag) error {
-	if flag.CanWrite() && flag.CanRead() {
-		return jengaerr.OpenRWFlagError.Format("BlockV2")
-	}
 	err := bf.f.Open(flag)
 	if err != nil {
 		return err
@@ -54,19 +82,31 @@ func (bf *blockV2) Open(flag flags.OpenFlag) error {
 }
 
 func (bf *blockV2) loadMeta(flag flags.OpenFlag) error {
-	if bf.f.cur != BlkFileHeadSize {
-		err := bf.f.seek(BlkFileHeadSize)
+	// V3格式直接使用尾部索引
+	if bf.f.index != nil {
+		for _, n := range bf.f.index {
+			bf.storeMeta(n)
+		}
+		return nil
+	}
+	if bf.f.cur != bf.f.start {
+		err := bf.f.seek(bf.f.start)
 		if err != nil {
 			return err
 		}
 	}
 	for {
+		start := bf.f.cur
 		n, err := bf.f.readBlock(nil)
 		if err != nil {
+			err = bf.f.tornTail(start, err)
 			// 最后一个
 			if errors.Is(err, io.EOF) {
-				if flag.CanRead() {
-					err := bf.f.seek(BlkFileHeadSize)
+				if flag.CanWrite() {
+					bf.f.cur, err = bf.f.file.Seek(0, io.SeekEnd)
+					return err
+				} else if flag.CanRead() {
+					err := bf.f.seek(bf.f.start)
 					if err != nil {
 						return err
 					}
@@ -76,17 +116,28 @@ func (bf *blockV2) loadMeta(flag flags.OpenFlag) error {
 				return err
 			}
 		}
-		if bf.filter != nil {
-			if bf.filter(n.key) {
-				bf.meta.Store(n.key, n)
-			}
-		} else {
+		bf.storeMeta(n)
+	}
+}
+
+func (bf *blockV2) storeMeta(n *blkNode) {
+	if n.deleted {
+		bf.meta.Delete(n.key)
+		return
+	}
+	if bf.filter != nil {
+		if bf.filter(n.key) {
 			bf.meta.Store(n.key, n)
 		}
+	} else {
+		bf.meta.Store(n.key, n)
 	}
 }
 
 func (bf *blockV2) Close() error {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
 	return bf.f.Close()
 }
 
@@ -108,15 +159,74 @@ func (bf *blockV2) ReadFile(path string) (*BlkHeader, error) {
 }
 
 func (bf *blockV2) WriteBlock(key string, reader io.Reader) (int64, error) {
+	return bf.writeBlock(key, nil, reader)
+}
+
+// 写入数据及其元数据，文件需包含FeatureMeta
+func (bf *blockV2) WriteBlockWithMeta(key string, meta EntryMeta, reader io.Reader) (int64, error) {
+	if !bf.f.header.HasFeature(FeatureMeta) {
+		return 0, jengaerr.NotSupportError.Format("Jenga file without meta feature", "WriteBlockWithMeta")
+	}
+	return bf.writeBlock(key, &meta, reader)
+}
+
+// 写入已压缩的数据（如其他工具生成的.bz2文件），数据原样保存，读取时使用compressType对应的压缩器解压。
+// compressType与文件的DATA FORMAT不同时文件需包含FeatureEntryCompress，可使用只能解压的压缩器（如bzip2）
+func (bf *blockV2) WriteRawBlock(key string, compressType compressor.Type, reader io.Reader) (int64, error) {
+	return bf.write(key, func() (*blkNode, error) {
+		return bf.f.writeRawData(key, nil, compressType, reader)
+	})
+}
+
+func (bf *blockV2) writeBlock(key string, meta *EntryMeta, reader io.Reader) (int64, error) {
+	return bf.write(key, func() (*blkNode, error) {
+		return bf.f.writeBlock(key, meta, reader)
+	})
+}
+
+func (bf *blockV2) write(key string, write func() (*blkNode, error)) (int64, error) {
 	if bf.filter != nil && !bf.filter(key) {
 		return 0, jengaerr.WriteKeyFilteredError
 	}
-	if _, ok := bf.meta.LoadOrStore(key, &blkNode{
-		key: key,
-	}); ok {
-		return 0, jengaerr.WriteExistKeyError.Format(key)
+	if !bf.overwrite {
+		if _, ok := bf.meta.LoadOrStore(key, &blkNode{
+			key: key,
+		}); ok {
+			return 0, jengaerr.WriteExistKeyError.Format(key)
+		}
+	}
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	node, err := write()
+	if err != nil {
+		// 写入失败时移除占位，之后可重新写入该key
+		if !bf.overwrite {
+			bf.meta.Delete(key)
+		}
+		if node == nil {
+			return 0, err
+		}
+		return node.originSize, err
+	}
+	// 写入完成后记录位置，读写模式下可立即读取
+	bf.meta.Store(key, node)
+	return node.originSize, nil
+}
+
+func (bf *blockV2) DeleteBlock(key string) error {
+	if _, ok := bf.meta.Load(key); !ok {
+		return jengaerr.ReadKeyNotFoundError.Format(key)
 	}
-	return bf.f.WriteBlock(key, reader)
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	err := bf.f.WriteTombstone(key)
+	if err != nil {
+		return err
+	}
+	bf.meta.Delete(key)
+	return nil
 }
 
 func (bf *blockV2) NeedSize() bool {
@@ -136,37 +246,198 @@ func (bf *blockV2) Keys() []string {
 	return ret
 }
 
+func (bf *blockV2) OpenBlock(key string) (io.ReadCloser, error) {
+	node, err := bf.loadNode(key)
+	if err != nil {
+		return nil, err
+	}
+	if node.compress == compressor.TypeNone && !bf.f.header.HasFeature(FeatureChecksum) && !bf.f.isStream() && bf.f.cipher == nil {
+		return &sectionReadCloser{bf.f.section(node)}, nil
+	}
+	r := bf.f.payload(node)
+	pr, pw := io.Pipe()
+	go func() {
+		n, _, err := bf.f.decompressFrom(pw, r, node)
+		if err == nil && n != node.size {
+			err = jengaerr.ReadNodeSizeNotMatchError
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+func (bf *blockV2) OpenBlockSection(key string) (*io.SectionReader, error) {
+	if bf.f.isStream() {
+		return nil, jengaerr.NotSupportError.Format("Stream format", "io.SectionReader")
+	}
+	if bf.f.cipher != nil {
+		return nil, jengaerr.NotSupportError.Format("Encrypted data", "io.SectionReader")
+	}
+	node, err := bf.loadNode(key)
+	if err != nil {
+		return nil, err
+	}
+	if node.compress != compressor.TypeNone {
+		return nil, jengaerr.NotSupportError.Format(compressor.GetName(node.compress), "io.SectionReader")
+	}
+	return bf.f.section(node), nil
+}
+
+// 计算文件头及全部实体的摘要并签名。V3格式以写入模式打开时，签名在Close时写入尾部
+func (bf *blockV2) Sign(priv ed25519.PrivateKey) (*Manifest, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+	return bf.f.Sign(priv)
+}
+
+// 使用信任的公钥校验签名及文件数据，m为nil时使用尾部记录的签名
+func (bf *blockV2) Verify(pub ed25519.PublicKey, m *Manifest) error {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+	return bf.f.Verify(pub, m)
+}
+
+// 打开时移除（写入模式）或忽略（只读模式）的未提交尾部大小
+func (bf *blockV2) Torn() int64 {
+	return bf.f.Torn()
+}
+
+// 获得Merkle根，仅支持V3格式
+func (bf *blockV2) MerkleRoot() ([]byte, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+	return bf.f.MerkleRoot()
+}
+
+// 获得key的Merkle证明，仅支持V3格式
+func (bf *blockV2) Proof(key string) (*MerkleProof, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+	return bf.f.Proof(key)
+}
+
+// 获得V3文件尾部记录的签名，未签名时返回nil
+func (bf *blockV2) Manifest() *Manifest {
+	return bf.f.Manifest()
+}
+
+// 获得使用的压缩器，只读打开后为文件头记录的压缩类型（包括字典）
+func (bf *blockV2) Compressor() compressor.Compressor {
+	return bf.f.compressor
+}
+
+func (bf *blockV2) StatBlock(key string) (EntryInfo, error) {
+	node, err := bf.loadNode(key)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	ret := EntryInfo{
+		Key:        key,
+		Size:       node.size,
+		OriginSize: node.originSize,
+		Offset:     node.offset,
+		Compress:   compressor.ToType(node.compress),
+	}
+	if node.meta != nil {
+		ret.EntryMeta = *node.meta
+	}
+	return ret, nil
+}
+
+func (bf *blockV2) loadNode(key string) (*blkNode, error) {
+	if v, ok := bf.meta.Load(key); ok {
+		node := v.(*blkNode)
+		if !node.invalid() {
+			return node, nil
+		}
+	}
+	return nil, jengaerr.ReadKeyNotFoundError.Format(key)
+}
+
 func (bf *blockV2) ReadBlockByKey(key string, w io.Writer) (int64, error) {
 	if v, ok := bf.meta.Load(key); ok {
 		node := v.(*blkNode)
 		if node.invalid() {
 			return 0, jengaerr.ReadKeyNotFoundError.Format(key)
 		}
-		err := bf.f.seek(node.offset)
-		if err != nil {
-			return 0, err
-		}
-		var n int64
-		if w != nil {
-			r := io.LimitReader(bf.f.file, node.size)
-			n, node.originSize, err = bf.f.compressor.Decompress(w, r)
-		} else {
-			n, err = bf.f.file.Seek(node.size, io.SeekCurrent)
-			n = n - bf.f.cur
+		if w == nil {
+			return node.readSize(), nil
 		}
-		bf.f.cur += n
+		// 使用ReadAt读取，不影响写入位置
+		n, originSize, err := bf.f.decompressFrom(w, bf.f.payload(node), node)
 		if err != nil {
-			return node.originSize, err
+			return originSize, err
 		}
 		if n != node.size {
-			return node.originSize, jengaerr.ReadNodeSizeNotMatchError
+			return originSize, jengaerr.ReadNodeSizeNotMatchError
 		}
-		return node.originSize, nil
+		return originSize, nil
 	} else {
 		return 0, jengaerr.ReadKeyNotFoundError.Format(key)
 	}
 }
 
+// 将有效数据写入dst（需以OpFlagWriteOnly打开），
+// dst为blockV2且可使用实体的压缩算法时直接复制压缩后的数据（dst包含FeatureEntryCompress时保留各实体的压缩算法）。
+func (bf *blockV2) Compact(dst JengaBlocks) error {
+	if !bf.f.flag.CanRead() {
+		return jengaerr.ReadFlagError
+	}
+	var nodes []*blkNode
+	bf.meta.Range(func(key, value interface{}) bool {
+		node := value.(*blkNode)
+		if !node.invalid() {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+	// 保持原有写入顺序
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].offset < nodes[j].offset
+	})
+	d, raw := dst.(*blockV2)
+	if raw {
+		raw = !d.f.header.HasFeature(FeatureChecksum) || bf.f.header.HasFeature(FeatureChecksum)
+	}
+	for _, node := range nodes {
+		var err error
+		if raw && d.f.canWriteRaw(bf.f, node) {
+			err = d.writeRawBlock(bf.f, node)
+		} else {
+			err = copyBlock(bf, dst, node.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bf *blockV2) writeRawBlock(src *BlkFileV2, node *blkNode) error {
+	if bf.filter != nil && !bf.filter(node.key) {
+		return jengaerr.WriteKeyFilteredError
+	}
+	_, ok := bf.meta.LoadOrStore(node.key, &blkNode{
+		key: node.key,
+	})
+	if ok && !bf.overwrite {
+		return jengaerr.WriteExistKeyError.Format(node.key)
+	}
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	n, err := bf.f.writeRawBlock(src, node)
+	if err != nil {
+		// 写入失败时移除占位（覆盖写入时保留原有的实体）
+		if !ok {
+			bf.meta.Delete(node.key)
+		}
+		return err
+	}
+	bf.meta.Store(node.key, n)
+	return nil
+}
+
 func (bf *blockV2) Flush() error {
 	return bf.f.Flush()
 }
@@ -181,6 +452,105 @@ func (opts blockV2Opts) WithCompressor(compressor compressor.Compressor) BlocksV
 	}
 }
 
+func (opts blockV2Opts) WithChecksum() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithChecksum()
+	}
+}
+
+// 新建文件时在每个实体之后写入提交记录（V3格式默认包含），写入模式打开时移除写入中断的实体
+func (opts blockV2Opts) WithCommit() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCommit()
+	}
+}
+
+func (opts blockV2Opts) WithMeta() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithMeta()
+	}
+}
+
+func (opts blockV2Opts) WithOriginSize() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithOriginSize()
+	}
+}
+
+// 新建V2文件时在每个实体记录压缩类型（V3默认包含）
+func (opts blockV2Opts) WithEntryCompress() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithEntryCompress()
+	}
+}
+
+// 根据key选择压缩器，返回nil时使用默认压缩器
+func (opts blockV2Opts) WithCompressorSelector(selector CompressorSelector) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressorSelector(selector)
+	}
+}
+
+// 指定扩展名（如".jpg"、".zip"，不区分大小写）的数据不压缩直接保存
+func (opts blockV2Opts) NoCompressExt(exts ...string) BlocksV2Opt {
+	return opts.WithCompressorSelector(NoCompressExtSelector(exts...))
+}
+
+// 使用口令加密数据，打开已加密的文件时需指定相同的口令
+func (opts blockV2Opts) WithPassphrase(passphrase string) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithPassphrase(passphrase)
+	}
+}
+
+// 使用EncryptKeySize字节的密钥加密数据，打开已加密的文件时需指定相同的密钥
+func (opts blockV2Opts) WithKey(key []byte) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithKey(key)
+	}
+}
+
+// 打开时使用公钥校验签名，未签名或数据被篡改时打开失败
+func (opts blockV2Opts) RequireSignature(pub ed25519.PublicKey) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithVerifyKey(pub)
+	}
+}
+
+// 打开时使用单独保存的签名（如.sig文件）校验，需同时指定RequireSignature
+func (opts blockV2Opts) WithDetachedSignature(sig []byte) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithDetachedSignature(sig)
+	}
+}
+
+// 新建文件时同时加密key及尾部索引，需指定口令或密钥
+func (opts blockV2Opts) WithEncryptKey() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithEncryptKey()
+	}
+}
+
+// 新建文件时使用的加密算法，默认为DefaultCipher
+func (opts blockV2Opts) WithCipher(c Cipher) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCipher(c)
+	}
+}
+
+func (opts blockV2Opts) WithStream() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithStream()
+	}
+}
+
+// 写入w（如管道、标准输出、http.ResponseWriter），需以OpFlagWriteOnly|OpFlagCreate打开
+func (opts blockV2Opts) WithWriter(w io.Writer) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f = NewBlkFileV2WithOpener(BlkFileV2Openers.Writer(w))
+	}
+}
+
 func (opts blockV2Opts) WithGzip() BlocksV2Opt {
 	return func(f *blockV2) {
 		f.f.WithCompressor(compressor.NewGzipCompressor())
@@ -193,12 +563,57 @@ func (opts blockV2Opts) WithZlib() BlocksV2Opt {
 	}
 }
 
+// 使用zstd压缩，可指定压缩等级及字典（compressor.ZstdOpts）
+func (opts blockV2Opts) WithZstd(zstdOpts ...compressor.ZstdOpt) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewZstdCompressor(zstdOpts...))
+	}
+}
+
+func (opts blockV2Opts) WithLz4(lz4Opts ...compressor.Lz4Opt) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewLz4Compressor(lz4Opts...))
+	}
+}
+
+func (opts blockV2Opts) WithSnappy() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewSnappyCompressor())
+	}
+}
+
+// 使用原始DEFLATE压缩，无gzip头部及校验，适合大量小数据。可指定压缩等级及预置字典（compressor.FlateOpts）
+func (opts blockV2Opts) WithFlate(flateOpts ...compressor.FlateOpt) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewFlateCompressor(flateOpts...))
+	}
+}
+
+// 采样估算压缩比，压缩比过低（如已压缩的数据）时不压缩直接保存，其他数据使用inner压缩（compressor.AdaptiveOpts）
+func (opts blockV2Opts) WithAdaptive(inner compressor.Compressor, adaptiveOpts ...compressor.AdaptiveOpt) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewAdaptiveCompressor(inner, adaptiveOpts...))
+	}
+}
+
+func (opts blockV2Opts) WithLzw(lzwOpts ...compressor.LzwOpt) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f.WithCompressor(compressor.NewLzwCompressor(lzwOpts...))
+	}
+}
+
 func (opts blockV2Opts) WithBlkFile(bf *BlkFileV2) BlocksV2Opt {
 	return func(f *blockV2) {
 		f.f = bf
 	}
 }
 
+func (opts blockV2Opts) WithBlkFileV3(bf *BlkFileV3) BlocksV2Opt {
+	return func(f *blockV2) {
+		f.f = bf.BlkFileV2
+	}
+}
+
 func (opts blockV2Opts) LocalFile(path string) BlocksV2Opt {
 	return func(f *blockV2) {
 		f.f = NewBlkFileV2(path)
@@ -211,6 +626,13 @@ func (opts blockV2Opts) WithOpener(openers Opener) BlocksV2Opt {
 	}
 }
 
+// 允许重复写入同一key，读取时使用最后写入的数据
+func (opts blockV2Opts) AllowOverwrite() BlocksV2Opt {
+	return func(f *blockV2) {
+		f.overwrite = true
+	}
+}
+
 func (opts blockV2Opts) WithKeyFilter(filter KeyFilter) BlocksV2Opt {
 	return func(f *blockV2) {
 		f.filter = filter
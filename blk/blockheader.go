@@ -9,7 +9,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/xfali/jenga/jengaerr"
+	"hash/crc32"
 	"io"
+	"strings"
 )
 
 const (
@@ -17,14 +19,74 @@ const (
 	BlkFileHeadSize               = 10
 	BlkFileBufferSize             = 32 * 1024
 	BlkHeaderUnknownOffset        = -1
+	BlkHeaderUnknownSize          = -1
+	// 删除标记实体的数据长度
+	BlkTombstoneSize uint64 = 0xFFFFFFFFFFFFFFFF
 )
 
+// 文件格式特性，记录于FileHeader.Reserve
+const (
+	// 实体包含原始数据的CRC32C校验值
+	FeatureChecksum uint16 = 1 << iota
+	// 实体包含元数据（EntryMeta）
+	FeatureMeta
+	// 实体头包含原始数据大小
+	FeatureOriginSize
+	// 实体使用分块格式写入，写入时无需Seek
+	FeatureStream
+	// 文件头之后为压缩字典（compressor.DictCompressor）
+	FeatureDict
+	// 实体记录各自的压缩类型，可与文件头的DATA FORMAT不同
+	FeatureEntryCompress
+	// 实体数据压缩后加密，文件头之后（字典之后）为加密信息
+	FeatureEncrypt
+	// key及尾部索引加密（需包含FeatureEncrypt）
+	FeatureEncryptKey
+	// 实体之后为提交记录，可检测写入中断的实体
+	FeatureCommit
+)
+
+// 压缩字典最大长度，超出时视为文件损坏
+const maxDictSize = 16 * 1024 * 1024
+
+// key（加密后）最大长度，超出时不可写入，读取时视为文件损坏
+const maxKeySize = 64 * 1024
+
+var featureNames = map[uint16]string{
+	FeatureChecksum:      "checksum",
+	FeatureMeta:          "meta",
+	FeatureOriginSize:    "origin-size",
+	FeatureStream:        "stream",
+	FeatureDict:          "dict",
+	FeatureEntryCompress: "entry-compress",
+	FeatureEncrypt:       "encrypt",
+	FeatureEncryptKey:    "encrypt-key",
+	FeatureCommit:        "commit",
+}
+
+// 已知的格式特性，文件包含其他特性时不能读写
+const featureMask = FeatureChecksum | FeatureMeta | FeatureOriginSize | FeatureStream | FeatureDict |
+	FeatureEntryCompress | FeatureEncrypt | FeatureEncryptKey | FeatureCommit
+
+// 文件包含未知的格式特性（如更新版本写入的文件）时返回错误
+func checkFeatures(h FileHeader) error {
+	if unknown := h.Reserve &^ featureMask; unknown != 0 {
+		return jengaerr.FeatureNotSupportError.Format(strings.Join(GetFeatureNames(unknown), ","))
+	}
+	return nil
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 type BlkHeader struct {
 	// block key(name)
 	Key string
 
 	// block size
 	Size int64
+
+	// 是否为删除标记
+	Deleted bool
 }
 
 type blkNode struct {
@@ -39,10 +101,35 @@ type blkNode struct {
 
 	// node offset
 	offset int64
+
+	// CRC32C of origin data
+	checksum uint32
+
+	// 压缩类型，未包含FeatureEntryCompress时为文件头的DATA FORMAT
+	compress uint16
+
+	// 删除标记
+	deleted bool
+
+	// 元数据（FeatureMeta）
+	meta *EntryMeta
+
+	// SHA-256 of origin data（V3格式，Merkle树的叶子）
+	digest []byte
 }
 
+// 写入中的占位节点尚未记录偏移（数据长度可能为0，不能用于判断）
 func (h *blkNode) invalid() bool {
-	return h.size == 0
+	return h.offset <= 0
+}
+
+// 不读取数据时返回的原始大小：未知（文件不包含FeatureOriginSize）时为0，与未记录原始大小的文件此前的返回值一致；
+// Stat返回的EntryInfo.OriginSize则为BlkHeaderUnknownSize
+func (h *blkNode) readSize() int64 {
+	if h.originSize == BlkHeaderUnknownSize {
+		return 0
+	}
+	return h.originSize
 }
 
 func NewBlkHeader(key string, size int64) *BlkHeader {
@@ -64,7 +151,28 @@ type FileHeader struct {
 	MagicCode  uint32
 	Version    uint16
 	DataFormat uint16
-	Reserve    uint16
+	// V2及以上版本用于记录格式特性（Feature*）
+	Reserve uint16
+}
+
+func (h FileHeader) HasFeature(feature uint16) bool {
+	return h.Reserve&feature != 0
+}
+
+// 获得格式特性名称
+func GetFeatureNames(features uint16) []string {
+	var ret []string
+	for i := uint(0); i < 16; i++ {
+		f := uint16(1) << i
+		if features&f != 0 {
+			if name, ok := featureNames[f]; ok {
+				ret = append(ret, name)
+			} else {
+				ret = append(ret, fmt.Sprintf("unknown(%d)", f))
+			}
+		}
+	}
+	return ret
 }
 
 func ReadFileHeader(r io.Reader) (FileHeader, error) {
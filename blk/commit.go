@@ -0,0 +1,152 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+)
+
+const (
+	BlkCommitMagic uint32 = 0x58466AFD
+	BlkCommitSize         = 12
+)
+
+// Commit format(FeatureCommit，位于每个实体之后):
+// |COMMIT MAGIC(4 Bytes)|ENTITY SIZE(8 Bytes)|
+// ENTITY SIZE为实体（不包括提交记录）的大小。提交记录在实体完整写入后写入，
+// 缺失或不匹配的实体视为写入中断（如进程异常退出）。
+func commitRecord(size int64) []byte {
+	buf := make([]byte, BlkCommitSize)
+	binary.BigEndian.PutUint32(buf, BlkCommitMagic)
+	binary.BigEndian.PutUint64(buf[4:], uint64(size))
+	return buf
+}
+
+// 写入start处实体的提交记录
+func (bf *BlkFileV2) writeCommit(start int64) error {
+	if !bf.header.HasFeature(FeatureCommit) {
+		return nil
+	}
+	wn, err := bf.file.Write(commitRecord(bf.cur - start))
+	bf.cur += int64(wn)
+	return err
+}
+
+// 读取并校验start处实体的提交记录
+func (bf *BlkFileV2) readCommit(start int64) error {
+	if !bf.header.HasFeature(FeatureCommit) {
+		return nil
+	}
+	size := bf.cur - start
+	buf := make([]byte, BlkCommitSize)
+	rn, err := io.ReadFull(bf.file, buf)
+	bf.cur += int64(rn)
+	if err != nil {
+		return unexpected(err)
+	}
+	if !bytes.Equal(buf, commitRecord(size)) {
+		return jengaerr.ReadCommitNotMatchError.Format(start)
+	}
+	return nil
+}
+
+// 打开时移除（写入模式）或忽略（只读模式）的未提交尾部大小
+func (bf *BlkFileV2) Torn() int64 {
+	return bf.torn
+}
+
+// 读取start处的实体失败时，判断start之后是否为未提交的尾部：文件包含FeatureCommit且之后不包含有效的提交记录。
+// 是则写入模式下移除该尾部，只读模式下视为实体结束，并返回io.EOF；
+// 否则（如文件中间的数据已损坏）返回原错误
+func (bf *BlkFileV2) tornTail(start int64, err error) error {
+	if !bf.header.HasFeature(FeatureCommit) {
+		return err
+	}
+	end, serr := bf.file.Seek(0, io.SeekEnd)
+	if serr != nil {
+		return serr
+	}
+	if start >= end {
+		return err
+	}
+	found, serr := bf.findCommit(start, end)
+	if serr != nil {
+		return serr
+	}
+	if found {
+		return err
+	}
+	bf.torn = end - start
+	if bf.flag.CanWrite() {
+		serr = bf.truncate(start)
+		if serr != nil {
+			return serr
+		}
+	} else {
+		// 只读模式下不修改文件，读取至未提交的尾部时结束
+		bf.trailerOff = start
+	}
+	serr = bf.seek(start)
+	if serr != nil {
+		return serr
+	}
+	return io.EOF
+}
+
+// [start, end)中是否包含位于start之后的实体的提交记录。
+// 数据中可能包含与提交记录相同的字节（如未压缩的jenga文件），需按实体格式校验
+func (bf *BlkFileV2) findCommit(start, end int64) (bool, error) {
+	magic := commitRecord(0)[:4]
+	buf := make([]byte, BlkFileBufferSize+BlkCommitSize)
+	n := 0
+	for off := start; off < end; {
+		rn, err := bf.readerAt.ReadAt(buf[n:], off)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if rn == 0 {
+			return false, nil
+		}
+		off += int64(rn)
+		n += rn
+		// buf[0]位于文件的base处
+		base := off - int64(n)
+		for i := 0; i+BlkCommitSize <= n; i++ {
+			if !bytes.Equal(buf[i:i+4], magic) {
+				continue
+			}
+			size := int64(binary.BigEndian.Uint64(buf[i+4:]))
+			if size > 0 && size <= base+int64(i)-start && bf.isCommit(base+int64(i), size) {
+				return true, nil
+			}
+		}
+		// 保留末尾不足一个提交记录的数据
+		keep := BlkCommitSize - 1
+		if keep > n {
+			keep = n
+		}
+		copy(buf, buf[n-keep:n])
+		n = keep
+	}
+	return false, nil
+}
+
+// off处是否为实体的提交记录：off-size处的数据可按实体格式读取，实体结束于off且提交记录一致。
+// 读取后文件位置不确定，调用者需重新设置
+func (bf *BlkFileV2) isCommit(off, size int64) bool {
+	start := off - size
+	if bf.seek(start) != nil {
+		return false
+	}
+	_, err := bf.readEntity(nil)
+	if err != nil || bf.cur != off {
+		return false
+	}
+	return bf.readCommit(start) == nil
+}
@@ -0,0 +1,578 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/jengaerr"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// 加密算法（AEAD）
+type Cipher uint8
+
+const (
+	CipherAES256GCM        Cipher = 1
+	CipherChaCha20Poly1305 Cipher = 2
+
+	// 默认加密算法
+	DefaultCipher = CipherAES256GCM
+	// WithKey使用的密钥大小
+	EncryptKeySize = 32
+)
+
+const (
+	// 使用口令：scrypt
+	kdfScrypt byte = 1
+	// 使用密钥：HKDF-SHA256
+	kdfHKDF byte = 2
+
+	// scrypt参数：N = 1 << scryptLogN
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+	// 读取时允许的最大scrypt参数，超出时视为文件损坏
+	maxScryptLogN = 20
+
+	encryptSaltSize    = 16
+	maxEncryptSaltSize = 1024
+	// 分块的明文大小
+	encryptChunkSize = 64 * 1024
+	// 每个实体随机生成的salt，用于派生实体的子密钥
+	encryptEntitySaltSize = 16
+	// 实体分块的nonce：|0(7 Bytes)|COUNTER(4 Bytes)|LAST(1 Byte)|，子密钥每个实体不同，nonce不会重复
+	encryptCounterOffset = 7
+	encryptNonceSize     = 12
+	encryptTagSize       = 16
+)
+
+var cipherNames = map[Cipher]string{
+	CipherAES256GCM:        "aes-256-gcm",
+	CipherChaCha20Poly1305: "chacha20-poly1305",
+}
+
+func (c Cipher) String() string {
+	if v, ok := cipherNames[c]; ok {
+		return v
+	}
+	return "unknown"
+}
+
+// 根据名称获得加密算法，如aes-256-gcm、chacha20-poly1305
+func GetCipher(name string) (Cipher, bool) {
+	for k, v := range cipherNames {
+		if v == name {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// 使用口令加密数据，新建文件时使用scrypt及随机salt派生密钥
+func (bf *BlkFileV2) WithPassphrase(passphrase string) *BlkFileV2 {
+	bf.passphrase = []byte(passphrase)
+	bf.secretKey = nil
+	return bf
+}
+
+// 使用EncryptKeySize字节的密钥加密数据，新建文件时使用HKDF-SHA256及随机salt派生密钥
+func (bf *BlkFileV2) WithKey(key []byte) *BlkFileV2 {
+	bf.secretKey = append([]byte{}, key...)
+	bf.passphrase = nil
+	return bf
+}
+
+// 新建文件时使用的加密算法，默认为DefaultCipher
+func (bf *BlkFileV2) WithCipher(c Cipher) *BlkFileV2 {
+	bf.cipherType = c
+	return bf
+}
+
+// 是否指定了口令或密钥
+func (bf *BlkFileV2) hasSecret() bool {
+	return len(bf.passphrase) > 0 || bf.secretKey != nil
+}
+
+// 文件头记录的加密信息（FeatureEncrypt），位于字典之后：
+// |CIPHER(1 Byte)|KDF(1 Byte)|VARINT(salt size)|SALT|[LOG N(1 Byte)|R(1 Byte)|P(1 Byte)]|NONCE(12 Bytes)|KEY CHECK(16 Bytes)|
+// scrypt参数仅KDF为scrypt时存在。KEY CHECK为使用派生密钥对之前的信息计算的认证标签，用于校验口令或密钥
+type encryptHeader struct {
+	cipher  Cipher
+	kdf     byte
+	salt    []byte
+	logN    byte
+	r       byte
+	p       byte
+	nonce   []byte
+	keyHash []byte
+}
+
+// KEY CHECK之前的信息
+func (h *encryptHeader) params() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(byte(h.cipher))
+	buf.WriteByte(h.kdf)
+	_ = writeVarint(buf, uint64(len(h.salt)))
+	buf.Write(h.salt)
+	if h.kdf == kdfScrypt {
+		buf.Write([]byte{h.logN, h.r, h.p})
+	}
+	return buf.Bytes()
+}
+
+func (h *encryptHeader) bytes() []byte {
+	ret := h.params()
+	ret = append(ret, h.nonce...)
+	return append(ret, h.keyHash...)
+}
+
+func readEncryptHeader(r io.Reader) (*encryptHeader, int64, error) {
+	h := &encryptHeader{}
+	buf := make([]byte, 3)
+	n, err := io.ReadFull(r, buf[:2])
+	size := int64(n)
+	if err != nil {
+		return nil, size, unexpected(err)
+	}
+	h.cipher, h.kdf = Cipher(buf[0]), buf[1]
+	saltSize, vn, err := readVarint(r)
+	size += int64(vn)
+	if err != nil {
+		return nil, size, unexpected(err)
+	}
+	if saltSize > maxEncryptSaltSize {
+		return nil, size, jengaerr.JengaBrokenError
+	}
+	h.salt = make([]byte, saltSize)
+	n, err = io.ReadFull(r, h.salt)
+	size += int64(n)
+	if err != nil {
+		return nil, size, unexpected(err)
+	}
+	if h.kdf == kdfScrypt {
+		n, err = io.ReadFull(r, buf)
+		size += int64(n)
+		if err != nil {
+			return nil, size, unexpected(err)
+		}
+		h.logN, h.r, h.p = buf[0], buf[1], buf[2]
+		if h.logN == 0 || h.logN > maxScryptLogN || h.r == 0 || h.p == 0 {
+			return nil, size, jengaerr.JengaBrokenError
+		}
+	}
+	check := make([]byte, encryptNonceSize+encryptTagSize)
+	n, err = io.ReadFull(r, check)
+	size += int64(n)
+	if err != nil {
+		return nil, size, unexpected(err)
+	}
+	h.nonce, h.keyHash = check[:encryptNonceSize], check[encryptNonceSize:]
+	return h, size, nil
+}
+
+// 新建文件时生成加密信息
+func (bf *BlkFileV2) newEncryptHeader() (*encryptHeader, error) {
+	h := &encryptHeader{
+		cipher: bf.cipherType,
+		kdf:    kdfHKDF,
+		salt:   make([]byte, encryptSaltSize),
+		nonce:  make([]byte, encryptNonceSize),
+	}
+	if h.cipher == 0 {
+		h.cipher = DefaultCipher
+	}
+	if len(bf.passphrase) > 0 {
+		h.kdf, h.logN, h.r, h.p = kdfScrypt, scryptLogN, scryptR, scryptP
+	}
+	if _, err := io.ReadFull(rand.Reader, h.salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, h.nonce); err != nil {
+		return nil, err
+	}
+	c, err := bf.deriveCipher(h)
+	if err != nil {
+		return nil, err
+	}
+	h.keyHash = c.aead.Seal(nil, h.nonce, nil, h.params())
+	bf.cipher = c
+	return h, nil
+}
+
+// 读取文件头之后的加密信息并校验口令或密钥。未加密的文件不能以写入模式指定口令或密钥
+func (bf *BlkFileV2) loadCipher(r io.Reader) (int64, error) {
+	bf.cipher = nil
+	if !bf.header.HasFeature(FeatureEncrypt) {
+		if bf.header.HasFeature(FeatureEncryptKey) {
+			return 0, jengaerr.JengaBrokenError
+		}
+		if bf.hasSecret() && bf.flag.CanWrite() {
+			return 0, jengaerr.NotSupportError.Format("Jenga file without encrypt feature", "encryption")
+		}
+		return 0, nil
+	}
+	h, n, err := readEncryptHeader(r)
+	if err != nil {
+		return n, err
+	}
+	if !bf.hasSecret() {
+		return n, jengaerr.EncryptKeyRequiredError
+	}
+
+	c, err := bf.deriveCipher(h)
+	if err != nil {
+		return n, err
+	}
+	if _, err := c.aead.Open(nil, h.nonce, h.keyHash, h.params()); err != nil {
+		return n, jengaerr.DecryptKeyError
+	}
+	bf.cipher = c
+	return n, nil
+}
+
+// 使用文件记录的salt派生密钥
+func (bf *BlkFileV2) deriveCipher(h *encryptHeader) (*payloadCipher, error) {
+	var key []byte
+	switch h.kdf {
+	case kdfScrypt:
+		if len(bf.passphrase) == 0 {
+			return nil, jengaerr.DecryptKeyError
+		}
+		var err error
+		key, err = scrypt.Key(bf.passphrase, h.salt, 1<<h.logN, int(h.r), int(h.p), EncryptKeySize)
+		if err != nil {
+			return nil, err
+		}
+	case kdfHKDF:
+		if bf.secretKey == nil {
+			return nil, jengaerr.DecryptKeyError
+		}
+		if len(bf.secretKey) != EncryptKeySize {
+			return nil, jengaerr.EncryptKeySizeError.Format(EncryptKeySize, len(bf.secretKey))
+		}
+		key = make([]byte, EncryptKeySize)
+		_, err := io.ReadFull(hkdf.New(sha256.New, bf.secretKey, h.salt, []byte("jenga")), key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, jengaerr.JengaBrokenError
+	}
+	return newPayloadCipher(h.cipher, key)
+}
+
+// 实体数据的加密器，使用派生的密钥
+type payloadCipher struct {
+	cipher Cipher
+	aead   cipher.AEAD
+	key    []byte
+	// 加密key及尾部索引（FeatureEncryptKey），使用由key派生的子密钥
+	names cipher.AEAD
+}
+
+func newPayloadCipher(c Cipher, key []byte) (*payloadCipher, error) {
+	aead, err := newAEAD(c, key)
+	if err != nil {
+		return nil, err
+	}
+	subKey := make([]byte, EncryptKeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("jenga key name")), subKey)
+	if err != nil {
+		return nil, err
+	}
+	names, err := newAEAD(c, subKey)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadCipher{
+		cipher: c,
+		aead:   aead,
+		key:    key,
+		names:  names,
+	}, nil
+}
+
+// 使用实体的salt派生子密钥
+func (c *payloadCipher) entityAEAD(salt []byte) (cipher.AEAD, error) {
+	subKey := make([]byte, EncryptKeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, c.key, salt, []byte("jenga entity")), subKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(c.cipher, subKey)
+}
+
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAES256GCM:
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(b)
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	}
+	return nil, jengaerr.CipherNotSupportError.Format(c)
+}
+
+// 加密key或尾部索引：|NONCE(12 Bytes)|DATA|TAG(16 Bytes)|，nonce随机生成
+func (c *payloadCipher) sealName(data, ad []byte) ([]byte, error) {
+	ret := make([]byte, encryptNonceSize, encryptNonceSize+len(data)+encryptTagSize)
+	if _, err := io.ReadFull(rand.Reader, ret); err != nil {
+		return nil, err
+	}
+	return c.names.Seal(ret, ret, data, ad), nil
+}
+
+func (c *payloadCipher) openName(data, ad []byte) ([]byte, error) {
+	if len(data) < encryptNonceSize+encryptTagSize {
+		return nil, jengaerr.DecryptKeyNameError
+	}
+	ret, err := c.names.Open(nil, data[:encryptNonceSize], data[encryptNonceSize:], ad)
+	if err != nil {
+		return nil, jengaerr.DecryptKeyNameError
+	}
+	return ret, nil
+}
+
+// 新建文件时同时加密key及尾部索引，需指定口令或密钥
+func (bf *BlkFileV2) WithEncryptKey() *BlkFileV2 {
+	bf.features |= FeatureEncryptKey
+	return bf
+}
+
+// 写入的key，FeatureEncryptKey时为加密后的数据
+func (bf *BlkFileV2) encodeKey(key string) ([]byte, error) {
+	if !bf.header.HasFeature(FeatureEncryptKey) {
+		return []byte(key), nil
+	}
+	return bf.cipher.sealName([]byte(key), nil)
+}
+
+func (bf *BlkFileV2) decodeKey(data []byte) (string, error) {
+	if !bf.header.HasFeature(FeatureEncryptKey) {
+		return string(data), nil
+	}
+	key, err := bf.cipher.openName(data, nil)
+	return string(key), err
+}
+
+// 两个文件的实体数据能否直接复制：都未加密，或加密算法及密钥相同
+func sameCipher(a, b *payloadCipher) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.cipher == b.cipher && bytes.Equal(a.key, b.key)
+}
+
+// 压缩数据并写入w，加密时压缩后按分块加密
+// 返回原始数据大小及写入w的数据大小
+func (bf *BlkFileV2) compressTo(w io.Writer, c compressor.Compressor, reader io.Reader, key string) (int64, int64, error) {
+	if bf.cipher == nil {
+		return c.Compress(w, reader)
+	}
+	sw := compressor.NewSizeWriter(w)
+	ew, err := bf.cipher.newWriter(sw, key)
+	if err != nil {
+		return 0, sw.Size(), err
+	}
+	before, _, err := c.Compress(ew, reader)
+	if err == nil {
+		err = ew.Close()
+	}
+	return before, sw.Size(), err
+}
+
+// 将数据按分块加密写入w：|SALT(16 Bytes)|CHUNK_1|...|CHUNK_N|，
+// 每个分块为encryptChunkSize字节明文（最后一个分块可更小或为空）使用SALT派生的子密钥加密后的数据及认证标签。
+// 实体的key作为附加认证数据，nonce包含分块序号及最后分块标记，可发现分块的替换、重排及截断
+type sealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	n       int
+	out     []byte
+}
+
+func (c *payloadCipher) newWriter(w io.Writer, key string) (*sealWriter, error) {
+	salt := make([]byte, encryptEntitySaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := c.entityAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &sealWriter{
+		w:     w,
+		aead:  aead,
+		ad:    []byte(key),
+		nonce: make([]byte, encryptNonceSize),
+		buf:   make([]byte, encryptChunkSize),
+		out:   make([]byte, 0, encryptChunkSize+encryptTagSize),
+	}, nil
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.n == len(w.buf) {
+			// 之后还有数据，当前分块不是最后一个
+			if err := w.seal(false); err != nil {
+				return total, err
+			}
+		}
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}
+
+func (w *sealWriter) seal(last bool) error {
+	if w.counter == math.MaxUint32 {
+		return jengaerr.WriteFailedError
+	}
+	binary.BigEndian.PutUint32(w.nonce[encryptCounterOffset:], w.counter)
+	if last {
+		w.nonce[encryptNonceSize-1] = 1
+	}
+	w.out = w.aead.Seal(w.out[:0], w.nonce, w.buf[:w.n], w.ad)
+	w.counter++
+	w.n = 0
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+// 写入最后一个分块，不关闭w
+func (w *sealWriter) Close() error {
+	return w.seal(true)
+}
+
+// 读取并解密sealWriter写入的数据
+type openReader struct {
+	r       *bufio.Reader
+	c       *payloadCipher
+	aead    cipher.AEAD
+	ad      []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	plain   []byte
+	init    bool
+	last    bool
+	err     error
+	// 已读取的加密数据大小
+	size int64
+}
+
+func (c *payloadCipher) newReader(r io.Reader, key string) *openReader {
+	return &openReader{
+		r:     bufio.NewReader(r),
+		c:     c,
+		ad:    []byte(key),
+		nonce: make([]byte, encryptNonceSize),
+		buf:   make([]byte, encryptChunkSize+encryptTagSize),
+	}
+}
+
+func (r *openReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// 读取并解密下一个分块，数据不足一个分块或之后没有数据时为最后一个分块
+func (r *openReader) next() error {
+	if !r.init {
+		r.init = true
+		salt := make([]byte, encryptEntitySaltSize)
+		n, err := io.ReadFull(r.r, salt)
+		r.size += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return r.broken()
+		} else if err != nil {
+			return err
+		}
+		r.aead, err = r.c.entityAEAD(salt)
+		if err != nil {
+			return err
+		}
+	}
+	n, err := io.ReadFull(r.r, r.buf)
+	r.size += int64(n)
+	last := false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	} else if _, err = r.r.Peek(1); err == io.EOF {
+		last = true
+	} else if err != nil {
+		return err
+	}
+	if r.counter == math.MaxUint32 {
+		return r.broken()
+	}
+	binary.BigEndian.PutUint32(r.nonce[encryptCounterOffset:], r.counter)
+	if last {
+		r.nonce[encryptNonceSize-1] = 1
+	}
+	r.plain, err = r.aead.Open(r.buf[:0], r.nonce, r.buf[:n], r.ad)
+	if err != nil {
+		return r.broken()
+	}
+	r.counter++
+	r.last = last
+	return nil
+}
+
+// 数据不完整或认证失败
+func (r *openReader) broken() error {
+	return jengaerr.ReadDecryptFailedError.Format(string(r.ad))
+}
+
+// 解压完成后读取剩余数据，校验至最后一个分块。err为解压返回的错误，解密失败时返回解密的错误
+// 返回读取的加密数据大小
+func (r *openReader) finish(err error) (int64, error) {
+	if r.err != nil {
+		return r.size, r.err
+	}
+	if err != nil {
+		return r.size, err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return r.size, err
+}
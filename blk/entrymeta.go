@@ -0,0 +1,170 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bytes"
+	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+	"os"
+	"time"
+)
+
+// 元数据最大长度，超出时视为文件损坏
+const maxEntryMetaSize = 16 * 1024 * 1024
+
+// 实体元数据
+type EntryMeta struct {
+	// 文件权限及类型
+	Mode os.FileMode
+
+	// 修改时间
+	ModTime time.Time
+
+	// 所有者
+	Uid int
+	Gid int
+
+	// 自定义属性
+	Attrs map[string]string
+}
+
+func (m *EntryMeta) empty() bool {
+	return m.Mode == 0 && m.ModTime.IsZero() && m.Uid == 0 && m.Gid == 0 && len(m.Attrs) == 0
+}
+
+// 实体信息
+type EntryInfo struct {
+	// 数据关联的key
+	Key string
+
+	// 存储（压缩后）的数据大小
+	Size int64
+
+	// 原始数据大小，未知时为BlkHeaderUnknownSize
+	OriginSize int64
+
+	// 数据在文件中的偏移，未知时为BlkHeaderUnknownOffset
+	Offset int64
+
+	// 压缩类型
+	Compress compressor.Type
+
+	EntryMeta
+}
+
+// Meta format(FeatureMeta):
+// |VARINT(meta size)|VARINT(mode)|VARINT(mtime unix nano)|VARINT(uid)|VARINT(gid)|VARINT(attr count)|VARINT(key length)|KEY|VARINT(value length)|VALUE|...
+// meta为空时meta size为0
+func encodeEntryMeta(meta *EntryMeta) []byte {
+	if meta == nil {
+		return nil
+	}
+	buf := bytes.NewBuffer(nil)
+	_ = writeVarint(buf, uint64(meta.Mode))
+	var mtime int64
+	if !meta.ModTime.IsZero() {
+		mtime = meta.ModTime.UnixNano()
+	}
+	_ = writeVarint(buf, uint64(mtime))
+	_ = writeVarint(buf, uint64(int64(meta.Uid)))
+	_ = writeVarint(buf, uint64(int64(meta.Gid)))
+	_ = writeVarint(buf, uint64(len(meta.Attrs)))
+	for k, v := range meta.Attrs {
+		_ = writeVarint(buf, uint64(len(k)))
+		buf.WriteString(k)
+		_ = writeVarint(buf, uint64(len(v)))
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+func decodeEntryMeta(data []byte) (*EntryMeta, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	r := bytes.NewReader(data)
+	var v [4]uint64
+	for i := range v {
+		n, _, err := readVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = n
+	}
+	meta := &EntryMeta{
+		Mode: os.FileMode(v[0]),
+		Uid:  int(int64(v[2])),
+		Gid:  int(int64(v[3])),
+	}
+	if v[1] != 0 {
+		meta.ModTime = time.Unix(0, int64(v[1]))
+	}
+	count, _, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		meta.Attrs = make(map[string]string, count)
+	}
+	for i := uint64(0); i < count; i++ {
+		k, err := readMetaString(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readMetaString(r)
+		if err != nil {
+			return nil, err
+		}
+		meta.Attrs[k] = v
+	}
+	return meta, nil
+}
+
+func readMetaString(r *bytes.Reader) (string, error) {
+	size, _, err := readVarint(r)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(r.Len()) {
+		return "", jengaerr.JengaBrokenError
+	}
+	buf := make([]byte, size)
+	_, _ = r.Read(buf)
+	return string(buf), nil
+}
+
+// 写入|VARINT(meta size)|META|
+func writeEntryMeta(w io.Writer, meta *EntryMeta) (int64, error) {
+	data := encodeEntryMeta(meta)
+	vi := VarInt{}
+	vi.InitFromUInt64(uint64(len(data)))
+	n, err := w.Write(vi.Bytes())
+	if err != nil {
+		return int64(n), err
+	}
+	wn, err := w.Write(data)
+	return int64(n + wn), err
+}
+
+// 读取|VARINT(meta size)|META|
+func readEntryMeta(r io.Reader) (*EntryMeta, int64, error) {
+	size, n, err := readVarint(r)
+	if err != nil {
+		return nil, int64(n), err
+	}
+	if size > maxEntryMetaSize {
+		return nil, int64(n), jengaerr.JengaBrokenError
+	}
+	data := make([]byte, size)
+	rn, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, int64(n + rn), err
+	}
+	meta, err := decodeEntryMeta(data)
+	return meta, int64(n + rn), err
+}
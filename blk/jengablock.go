@@ -8,6 +8,7 @@ package jengablk
 import (
 	"github.com/xfali/jenga/flags"
 	"io"
+	"sync"
 )
 
 type BlockReadWriter interface {
@@ -24,9 +25,85 @@ type JengaBlocks interface {
 	Open(flag flags.OpenFlag) error
 	Keys() []string
 	WriteBlock(key string, reader io.Reader) (int64, error)
+	// 写入数据及其元数据
+	WriteBlockWithMeta(key string, meta EntryMeta, reader io.Reader) (int64, error)
+	DeleteBlock(key string) error
 	ReadBlock(w io.Writer) (*BlkHeader, error)
 	ReadBlockByKey(key string, writer io.Writer) (int64, error)
+	// 获得key关联数据的reader，数据在读取时解压
+	OpenBlock(key string) (io.ReadCloser, error)
+	// 获得key关联数据的io.SectionReader，仅支持未压缩的数据
+	OpenBlockSection(key string) (*io.SectionReader, error)
+	// 获得key关联数据的信息
+	StatBlock(key string) (EntryInfo, error)
 	Close() (err error)
 	NeedSize() bool
 	Flush() error
+
+	// 将有效数据写入dst，dst需以OpFlagWriteOnly打开
+	Compact(dst JengaBlocks) error
+}
+
+// 读取src中key关联的数据并写入dst
+func copyBlock(src, dst JengaBlocks, key string) error {
+	info, err := src.StatBlock(key)
+	if err != nil {
+		return err
+	}
+	r, w := io.Pipe()
+	go func() {
+		_, err := src.ReadBlockByKey(key, w)
+		_ = w.CloseWithError(err)
+	}()
+	if info.EntryMeta.empty() {
+		_, err = dst.WriteBlock(key, r)
+	} else {
+		_, err = dst.WriteBlockWithMeta(key, info.EntryMeta, r)
+	}
+	// 写入出错时结束读取
+	_ = r.CloseWithError(err)
+	return err
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (r *sectionReadCloser) Close() error {
+	return nil
+}
+
+// 获得f的io.ReaderAt，f不支持时使用Seek实现（读取后恢复原位置）
+func newReaderAt(f BlockReadWriter) io.ReaderAt {
+	if r, ok := f.(io.ReaderAt); ok {
+		return r
+	}
+	return &seekReaderAt{
+		f: f,
+	}
+}
+
+type seekReaderAt struct {
+	lock sync.Mutex
+	f    io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cur, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	defer r.f.Seek(cur, io.SeekStart)
+	_, err = r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
 }
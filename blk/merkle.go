@@ -0,0 +1,257 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+)
+
+const (
+	merkleLeafPrefix byte = 0
+	merkleNodePrefix byte = 1
+)
+
+// Merkle证明：叶子（实体）的位置、叶子个数及由叶子至根的路径
+// Merkle树（RFC 6962）的叶子按尾部索引的顺序排列，每个key最后写入且未删除的实体为一个叶子：
+// leaf = SHA-256(0x00|VARINT(key length)|KEY|SHA-256(origin data))
+// node = SHA-256(0x01|LEFT|RIGHT)
+// Format: |VARINT(index)|VARINT(size)|VARINT(path length)|HASH(32 Bytes)|...
+type MerkleProof struct {
+	Index uint64
+	Size  uint64
+	Path  [][]byte
+}
+
+func (p *MerkleProof) Bytes() []byte {
+	buf := bytes.NewBuffer(nil)
+	_ = writeVarint(buf, p.Index)
+	_ = writeVarint(buf, p.Size)
+	_ = writeVarint(buf, uint64(len(p.Path)))
+	for _, h := range p.Path {
+		buf.Write(h)
+	}
+	return buf.Bytes()
+}
+
+// 解析Merkle证明（MerkleProof.Bytes）
+func ParseMerkleProof(data []byte) (*MerkleProof, error) {
+	r := bytes.NewReader(data)
+	p := &MerkleProof{}
+	var err error
+	if p.Index, _, err = readVarint(r); err != nil {
+		return nil, err
+	}
+	if p.Size, _, err = readVarint(r); err != nil {
+		return nil, err
+	}
+	count, _, err := readVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(r.Len()) || uint64(r.Len()) != count*sha256.Size {
+		return nil, jengaerr.MerkleVerifyError.Format("proof format not match")
+	}
+	for i := uint64(0); i < count; i++ {
+		h := make([]byte, sha256.Size)
+		_, _ = r.Read(h)
+		p.Path = append(p.Path, h)
+	}
+	return p, nil
+}
+
+// 使用Merkle根校验key及原始数据
+func VerifyEntry(key string, data io.Reader, root []byte, proof *MerkleProof) error {
+	h := sha256.New()
+	_, err := io.Copy(h, data)
+	if err != nil {
+		return err
+	}
+	if proof == nil || proof.Index >= proof.Size {
+		return jengaerr.MerkleVerifyError.Format("invalid proof")
+	}
+	ret := merkleLeaf(key, h.Sum(nil))
+	// RFC 9162 2.1.3.2
+	fn, sn := proof.Index, proof.Size-1
+	for _, p := range proof.Path {
+		if sn == 0 {
+			return jengaerr.MerkleVerifyError.Format("proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			ret = merkleNode(p, ret)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			ret = merkleNode(ret, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(ret, root) {
+		return jengaerr.MerkleVerifyError.Format("root not match")
+	}
+	return nil
+}
+
+// 获得Merkle根，仅支持V3格式
+func (bf *BlkFileV2) MerkleRoot() ([]byte, error) {
+	leaves, _, err := bf.merkleLeaves()
+	if err != nil {
+		return nil, err
+	}
+	return merkleHash(leaves), nil
+}
+
+// 获得key的Merkle证明
+func (bf *BlkFileV2) Proof(key string) (*MerkleProof, error) {
+	leaves, index, err := bf.merkleLeaves()
+	if err != nil {
+		return nil, err
+	}
+	for i, n := range index {
+		if n.key == key {
+			return &MerkleProof{
+				Index: uint64(i),
+				Size:  uint64(len(leaves)),
+				Path:  merklePath(i, leaves),
+			}, nil
+		}
+	}
+	return nil, jengaerr.ReadKeyNotFoundError.Format(key)
+}
+
+func (bf *BlkFileV2) merkleLeaves() ([][]byte, []*blkNode, error) {
+	if !bf.hasTrailer() || bf.index == nil {
+		return nil, nil, jengaerr.MerkleNotFoundError
+	}
+	index := bf.liveIndex()
+	// 尾部未记录摘要时读取数据计算
+	err := bf.fillDigests(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaves := make([][]byte, len(index))
+	for i, n := range index {
+		leaves[i] = merkleLeaf(n.key, n.digest)
+	}
+	return leaves, index, nil
+}
+
+// 计算缺失的实体原始数据摘要（如旧版本写入或footer缺失后全量扫描的实体）
+func (bf *BlkFileV2) fillDigests(index []*blkNode) error {
+	for _, n := range index {
+		if n.digest != nil {
+			continue
+		}
+		h := sha256.New()
+		_, _, err := bf.decompressFrom(h, bf.payload(n), n)
+		if err != nil {
+			return err
+		}
+		n.digest = h.Sum(nil)
+	}
+	return nil
+}
+
+// 全部实体是否已有摘要
+func hasDigests(index []*blkNode) bool {
+	for _, n := range index {
+		if n.digest == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Merkle section: |VARINT(count)|SHA-256(origin data)|...，与索引section的顺序相同
+// 数据已加密时加密保存：|NONCE(12 Bytes)|DIGESTS(encrypted)|TAG(16 Bytes)|
+func (bf *BlkFileV2) encodeDigests(index []*blkNode) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	_ = writeVarint(buf, uint64(len(index)))
+	for _, n := range index {
+		buf.Write(n.digest)
+	}
+	if bf.cipher != nil {
+		return bf.cipher.sealName(buf.Bytes(), []byte{trailerSectionMerkle})
+	}
+	return buf.Bytes(), nil
+}
+
+func (bf *BlkFileV2) decodeDigests(data []byte, index []*blkNode) error {
+	if bf.cipher != nil {
+		var err error
+		data, err = bf.cipher.openName(data, []byte{trailerSectionMerkle})
+		if err != nil {
+			return err
+		}
+	}
+	r := bytes.NewReader(data)
+	count, _, err := readVarint(r)
+	if err != nil {
+		return err
+	}
+	if count != uint64(len(index)) || r.Len() != len(index)*sha256.Size {
+		return jengaerr.JengaBrokenError
+	}
+	for _, n := range index {
+		n.digest = make([]byte, sha256.Size)
+		_, _ = r.Read(n.digest)
+	}
+	return nil
+}
+
+func merkleLeaf(key string, digest []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	_ = writeVarint(h, uint64(len(key)))
+	h.Write([]byte(key))
+	h.Write(digest)
+	return h.Sum(nil)
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// 小于n的最大的2的幂
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		ret := sha256.Sum256(nil)
+		return ret[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNode(merkleHash(leaves[:k]), merkleHash(leaves[k:]))
+}
+
+func merklePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := merkleSplit(len(leaves))
+	if m < k {
+		return append(merklePath(m, leaves[:k]), merkleHash(leaves[k:]))
+	}
+	return append(merklePath(m-k, leaves[k:]), merkleHash(leaves[:k]))
+}
@@ -0,0 +1,513 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/jengaerr"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// 顺序读取V2、V3格式的jenga文件，无需Seek，可读取管道、标准输入、网络连接等。
+// 按写入顺序返回全部实体（包括被覆盖的实体及删除标记），使用方式与bufio.Scanner相同：
+//
+//	s := NewScanner(r)
+//	for s.Next() {
+//	    info := s.Entry()
+//	    _, err := s.Read(w)
+//	}
+//	err := s.Err()
+type Scanner struct {
+	r    *scanReader
+	f    *BlkFileV2
+	init bool
+	err  error
+
+	// 当前实体及其位置
+	node  *blkNode
+	start int64
+	// 当前实体未读取的（压缩）数据
+	payload io.Reader
+	// Reader()返回的pipe
+	pipe *io.PipeReader
+	done chan struct{}
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{
+		r: &scanReader{
+			r: bufio.NewReaderSize(r, BlkFileBufferSize),
+		},
+		f: NewBlkFileV2WithOpener(nil),
+	}
+}
+
+// 获得文件头，未读取时读取文件头
+func (s *Scanner) Header() (FileHeader, error) {
+	if !s.init {
+		s.init = true
+		s.err = s.readHeader()
+	}
+	return s.f.header, s.err
+}
+
+func (s *Scanner) readHeader() error {
+	h, err := ReadFileHeader(s.r)
+	if err != nil {
+		return err
+	}
+	if !IsV2Version(h.Version) && h.Version != BlkFileV3Version {
+		return jengaerr.VersionNotSupportError.Format(h.Version, BlkFileV3Version)
+	}
+	if err = checkFeatures(h); err != nil {
+		return err
+	}
+	s.f.header = h
+	_, err = s.f.loadCompressor(s.r)
+	if err == nil {
+		_, err = s.f.loadCipher(s.r)
+	}
+	return unexpected(err)
+}
+
+// 使用口令读取加密的文件，需在读取前调用
+func (s *Scanner) WithPassphrase(passphrase string) *Scanner {
+	s.f.WithPassphrase(passphrase)
+	return s
+}
+
+// 使用密钥读取加密的文件，需在读取前调用
+func (s *Scanner) WithKey(key []byte) *Scanner {
+	s.f.WithKey(key)
+	return s
+}
+
+// 读取下一个实体，读取完毕或发生错误时返回false，错误通过Err获得
+// 当前实体未读取的数据将被跳过
+func (s *Scanner) Next() bool {
+	if _, err := s.Header(); err != nil {
+		return false
+	}
+	if s.err == nil {
+		s.err = s.skip()
+	}
+	if s.err == nil && s.node != nil {
+		s.err = s.readCommit()
+	}
+	if s.err != nil {
+		return false
+	}
+	s.node = nil
+	if s.f.hasTrailer() {
+		end, err := s.skipTrailer()
+		if err != nil || end {
+			s.err = err
+			return false
+		}
+	}
+	node, err := s.readEntity()
+	if err != nil {
+		// 实体边界处结束视为读取完毕
+		if err != io.EOF {
+			s.err = err
+		}
+		return false
+	}
+	s.node = node
+	return true
+}
+
+// 读取过程中发生的错误，正常读取完毕时返回nil
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// 当前实体的信息。分块格式（FeatureStream）的实体在数据读取完毕前大小未知，为BlkHeaderUnknownSize
+func (s *Scanner) Entry() EntryInfo {
+	if s.node == nil {
+		return EntryInfo{}
+	}
+	ret := EntryInfo{
+		Key:        s.node.key,
+		Size:       s.node.size,
+		OriginSize: s.node.originSize,
+		Offset:     s.node.offset,
+		Compress:   compressor.ToType(s.node.compress),
+	}
+	if s.node.meta != nil {
+		ret.EntryMeta = *s.node.meta
+	}
+	return ret
+}
+
+// 当前实体是否为删除标记
+func (s *Scanner) Deleted() bool {
+	return s.node != nil && s.node.deleted
+}
+
+// 将当前实体的数据解压至w，每个实体只能读取一次
+// 返回解压后的数据大小
+func (s *Scanner) Read(w io.Writer) (int64, error) {
+	if s.node == nil || s.node.deleted || s.payload == nil || s.pipe != nil {
+		return 0, jengaerr.ReadFailedError
+	}
+	return s.read(w)
+}
+
+// 获得当前实体数据的reader，数据在读取时解压。调用Next时未读取的数据将被丢弃
+func (s *Scanner) Reader() io.Reader {
+	if s.node == nil || s.node.deleted || s.payload == nil || s.pipe != nil {
+		return bytes.NewReader(nil)
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		_, err := s.read(pw)
+		_ = pw.CloseWithError(err)
+		close(done)
+	}()
+	s.pipe = pr
+	s.done = done
+	return pr
+}
+
+func (s *Scanner) read(w io.Writer) (int64, error) {
+	node := s.node
+	var h hash.Hash32
+	if s.f.header.HasFeature(FeatureChecksum) {
+		h = crc32.New(castagnoliTable)
+		w = io.MultiWriter(w, h)
+	}
+	c, err := s.f.entryCompressor(node.compress)
+	if err != nil {
+		return 0, err
+	}
+	r := s.payload
+	var or *openReader
+	if s.f.cipher != nil {
+		or = s.f.cipher.newReader(r, node.key)
+		r = or
+	}
+	n, originSize, err := c.Decompress(w, r)
+	if or != nil {
+		n, err = or.finish(err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 读取剩余数据及分块格式的实体头
+	err = s.skipPayload()
+	if err != nil {
+		return 0, err
+	}
+	if n != node.size {
+		return 0, jengaerr.ReadNodeSizeNotMatchError
+	}
+	if h != nil && h.Sum32() != node.checksum {
+		return 0, jengaerr.ReadChecksumNotMatchError.Format(node.key)
+	}
+	node.originSize = originSize
+	return originSize, nil
+}
+
+// 跳过当前实体未读取的数据
+func (s *Scanner) skip() error {
+	if s.pipe != nil {
+		_ = s.pipe.Close()
+		<-s.done
+		s.pipe = nil
+	}
+	return s.skipPayload()
+}
+
+func (s *Scanner) skipPayload() error {
+	if s.payload == nil {
+		return nil
+	}
+	payload := s.payload
+	s.payload = nil
+	_, err := io.Copy(ioutil.Discard, payload)
+	if err != nil {
+		return err
+	}
+	switch r := payload.(type) {
+	case *io.LimitedReader:
+		if r.N > 0 {
+			return io.ErrUnexpectedEOF
+		}
+	case *chunkReader:
+		_, err = s.readStreamHead(s.node, r.size)
+	}
+	return err
+}
+
+func (s *Scanner) readEntity() (*blkNode, error) {
+	s.start = s.r.off
+	size, n, err := readVarint(s.r)
+	if err != nil {
+		if err == io.EOF && n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if size > maxKeySize {
+		return nil, jengaerr.JengaBrokenError
+	}
+	key := make([]byte, size)
+	_, err = io.ReadFull(s.r, key)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	k, err := s.f.decodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	node := &blkNode{
+		key:      k,
+		compress: s.f.header.DataFormat,
+	}
+	if s.f.header.HasFeature(FeatureEntryCompress) {
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(s.r, buf)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		node.compress = binary.BigEndian.Uint16(buf)
+	}
+	if s.f.isStream() {
+		return s.readStreamEntity(node)
+	}
+	v, err := s.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	if s.f.header.HasFeature(FeatureOriginSize) {
+		node.originSize, err = s.readSize()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		node.originSize = BlkHeaderUnknownSize
+	}
+	if s.f.header.HasFeature(FeatureChecksum) {
+		node.checksum, err = s.readChecksum()
+		if err != nil {
+			return nil, err
+		}
+	}
+	node.offset = s.r.off
+	if v == BlkTombstoneSize {
+		node.deleted = true
+		return node, nil
+	}
+	node.size = int64(v)
+	if s.f.header.HasFeature(FeatureMeta) {
+		node.meta, _, err = readEntryMeta(s.r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		node.offset = s.r.off
+	}
+	s.payload = io.LimitReader(s.r, node.size)
+	return node, nil
+}
+
+func (s *Scanner) readStreamEntity(node *blkNode) (*blkNode, error) {
+	var err error
+	if s.f.header.HasFeature(FeatureMeta) {
+		node.meta, _, err = readEntryMeta(s.r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+	}
+	node.offset = s.r.off
+	node.size = BlkHeaderUnknownSize
+	node.originSize = BlkHeaderUnknownSize
+	b, err := s.r.peek(1)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if b[0] != 0 {
+		s.payload = newChunkReader(s.r)
+		return node, nil
+	}
+	// 不包含分块：空数据或删除标记，实体头紧随其后
+	_, _ = io.CopyN(ioutil.Discard, s.r, 1)
+	tombstone, err := s.readStreamHead(node, 0)
+	if err != nil {
+		return nil, err
+	}
+	if tombstone {
+		node.meta = nil
+		node.deleted = true
+	} else {
+		s.payload = bytes.NewReader(nil)
+	}
+	return node, nil
+}
+
+// 读取分块之后的实体头，size为分块数据的总大小
+func (s *Scanner) readStreamHead(node *blkNode, size int64) (bool, error) {
+	v, err := s.readUint64()
+	if err != nil {
+		return false, err
+	}
+	originSize := int64(BlkHeaderUnknownSize)
+	if s.f.header.HasFeature(FeatureOriginSize) {
+		originSize, err = s.readSize()
+		if err != nil {
+			return false, err
+		}
+	}
+	if s.f.header.HasFeature(FeatureChecksum) {
+		node.checksum, err = s.readChecksum()
+		if err != nil {
+			return false, err
+		}
+	}
+	if v == BlkTombstoneSize && size == 0 {
+		return true, nil
+	}
+	if int64(v) != size {
+		return false, jengaerr.ReadNodeSizeNotMatchError
+	}
+	node.size = size
+	node.originSize = originSize
+	return false, nil
+}
+
+// 读取并校验当前实体的提交记录（FeatureCommit）
+func (s *Scanner) readCommit() error {
+	if !s.f.header.HasFeature(FeatureCommit) {
+		return nil
+	}
+	size := s.r.off - s.start
+	buf := make([]byte, BlkCommitSize)
+	_, err := io.ReadFull(s.r, buf)
+	if err != nil {
+		return unexpected(err)
+	}
+	if !bytes.Equal(buf, commitRecord(size)) {
+		return jengaerr.ReadCommitNotMatchError.Format(s.start)
+	}
+	return nil
+}
+
+func (s *Scanner) readUint64() (uint64, error) {
+	buf := make([]byte, 8)
+	_, err := io.ReadFull(s.r, buf)
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+func (s *Scanner) readSize() (int64, error) {
+	v, err := s.readUint64()
+	return int64(v), err
+}
+
+func (s *Scanner) readChecksum() (uint32, error) {
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(s.r, buf)
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	return binary.BigEndian.Uint32(buf), nil
+}
+
+// V3格式：当前位置为尾部起始标记时读取至EOF，校验footer后返回true。
+// 尾部数据不做缓存，仅保留末尾footer大小的数据；footer缺失（写入尾部时进程退出）时视为读取完毕
+func (s *Scanner) skipTrailer() (bool, error) {
+	b, err := s.r.peek(BlkFileV3TrailerMagicSize)
+	if !isTrailerMagic(b) {
+		return false, ignoreEOF(err)
+	}
+	offset := s.r.off
+	crc := crc32.NewIEEE()
+	buf := make([]byte, BlkFileV3FooterSize+BlkFileBufferSize)
+	n := 0
+	for {
+		rn, err := s.r.Read(buf[n:])
+		n += rn
+		if n > BlkFileV3FooterSize {
+			crc.Write(buf[:n-BlkFileV3FooterSize])
+			n = copy(buf, buf[n-BlkFileV3FooterSize:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	footer := buf[:n]
+	if n < BlkFileV3FooterSize || binary.BigEndian.Uint32(footer[12:]) != BlkFileV3FooterMagic {
+		return true, nil
+	}
+	if int64(binary.BigEndian.Uint64(footer)) != offset || crc.Sum32() != binary.BigEndian.Uint32(footer[8:]) {
+		return true, jengaerr.JengaBrokenError
+	}
+	return true, nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// 支持预读的reader，记录已读取的位置
+type scanReader struct {
+	r   io.Reader
+	buf []byte
+	off int64
+}
+
+func (r *scanReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(r.buf) > 0 {
+		n = copy(p, r.buf)
+		r.buf = r.buf[n:]
+	} else {
+		n, err = r.r.Read(p)
+	}
+	r.off += int64(n)
+	return n, err
+}
+
+// 预读n个字节，不改变读取位置。数据不足时返回已读取的数据及错误
+// 缓存按实际读取的数据增长，不会预先分配n个字节
+func (r *scanReader) peek(n int) ([]byte, error) {
+	for len(r.buf) < n {
+		size := n - len(r.buf)
+		if size > BlkFileBufferSize {
+			size = BlkFileBufferSize
+		}
+		buf := make([]byte, size)
+		rn, err := io.ReadFull(r.r, buf)
+		r.buf = append(r.buf, buf[:rn]...)
+		if err != nil {
+			return r.buf, err
+		}
+	}
+	return r.buf[:n], nil
+}
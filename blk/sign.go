@@ -0,0 +1,246 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+)
+
+const (
+	// ed25519签名
+	SignAlgorithmEd25519 byte = 1
+	// 签名清单大小
+	ManifestSize = 4 + 1 + 1 + 8 + sha256.Size + sha256.Size + ed25519.PublicKeySize + ed25519.SignatureSize
+
+	manifestMagic          = "JSIG"
+	manifestVersion   byte = 2
+	manifestSignedLen      = ManifestSize - ed25519.SignatureSize
+)
+
+// 签名清单：文件头及全部实体（不包括尾部索引）的SHA-256摘要及ed25519签名。
+// 可写入V3文件的尾部（signature section），或单独保存（如.sig文件）
+// Format:
+// |MAGIC "JSIG"(4 Bytes)|VERSION(1 Byte)|ALGORITHM(1 Byte)|SIZE(8 Bytes)|SHA-256(32 Bytes)|MERKLE ROOT(32 Bytes)|PUBLIC KEY(32 Bytes)|SIGNATURE(64 Bytes)|
+// SIGNATURE为对之前全部数据的签名，MERKLE ROOT在不包含Merkle树（V2格式）时为全0
+type Manifest struct {
+	// 签名数据（文件头及全部实体）的大小
+	Size int64
+	// 签名数据的SHA-256摘要
+	Digest [sha256.Size]byte
+	// 签名时的Merkle根，可用于校验单个实体的Merkle证明（VerifyEntry）
+	Root [sha256.Size]byte
+	// 签名使用的公钥
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+func (m *Manifest) Bytes() []byte {
+	buf := make([]byte, ManifestSize)
+	copy(buf, manifestMagic)
+	buf[4] = manifestVersion
+	buf[5] = SignAlgorithmEd25519
+	binary.BigEndian.PutUint64(buf[6:], uint64(m.Size))
+	copy(buf[14:], m.Digest[:])
+	copy(buf[14+sha256.Size:], m.Root[:])
+	copy(buf[14+2*sha256.Size:], m.PublicKey)
+	copy(buf[manifestSignedLen:], m.Signature)
+	return buf
+}
+
+// 解析签名清单（Manifest.Bytes）
+func ParseManifest(data []byte) (*Manifest, error) {
+	if len(data) != ManifestSize || string(data[:4]) != manifestMagic {
+		return nil, jengaerr.SignatureVerifyError.Format("manifest format not match")
+	}
+	if data[4] != manifestVersion || data[5] != SignAlgorithmEd25519 {
+		return nil, jengaerr.SignatureVerifyError.Format("manifest version or algorithm not support")
+	}
+	m := &Manifest{
+		Size:      int64(binary.BigEndian.Uint64(data[6:])),
+		PublicKey: append(ed25519.PublicKey{}, data[14+2*sha256.Size:manifestSignedLen]...),
+		Signature: append([]byte{}, data[manifestSignedLen:]...),
+	}
+	copy(m.Digest[:], data[14:])
+	copy(m.Root[:], data[14+sha256.Size:])
+	return m, nil
+}
+
+// 使用信任的公钥校验清单的签名，不校验文件数据
+func (m *Manifest) Verify(pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize || !bytes.Equal(m.PublicKey, pub) {
+		return jengaerr.SignatureVerifyError.Format("public key not match")
+	}
+	if !ed25519.Verify(pub, m.Bytes()[:manifestSignedLen], m.Signature) {
+		return jengaerr.SignatureVerifyError.Format("invalid signature")
+	}
+	return nil
+}
+
+// 打开时校验签名，未签名或数据被篡改时打开失败。
+// 尾部索引不在签名范围内，校验通过后根据实体重建索引
+func (bf *BlkFileV2) WithVerifyKey(pub ed25519.PublicKey) *BlkFileV2 {
+	bf.verifyKey = pub
+	return bf
+}
+
+// 打开时使用单独保存的签名（如.sig文件）校验，需同时指定WithVerifyKey
+func (bf *BlkFileV2) WithDetachedSignature(sig []byte) *BlkFileV2 {
+	bf.detachedSig = sig
+	return bf
+}
+
+// 获得V3文件尾部记录的签名，未签名时返回nil
+func (bf *BlkFileV2) Manifest() *Manifest {
+	return bf.manifest
+}
+
+// 计算文件头及全部实体的摘要并签名。
+// V3格式以写入模式打开时，签名在Close时写入尾部，签名之后写入数据将使签名失效（Close时不写入）
+func (bf *BlkFileV2) Sign(priv ed25519.PrivateKey) (*Manifest, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, jengaerr.SignKeyError
+	}
+	size, err := bf.signedSize()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Size:      size,
+		PublicKey: priv.Public().(ed25519.PublicKey),
+	}
+	m.Digest, err = bf.digest(size)
+	if err != nil {
+		return nil, err
+	}
+	m.Root, err = bf.signedRoot()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = ed25519.Sign(priv, m.Bytes()[:manifestSignedLen])
+	if bf.flag.CanWrite() && bf.hasTrailer() {
+		bf.manifest = m
+	}
+	return m, nil
+}
+
+// 使用信任的公钥校验签名及文件数据，m为nil时使用尾部记录的签名
+func (bf *BlkFileV2) Verify(pub ed25519.PublicKey, m *Manifest) error {
+	if m == nil {
+		m = bf.manifest
+	}
+	if m == nil {
+		return jengaerr.SignatureNotFoundError
+	}
+	err := m.Verify(pub)
+	if err != nil {
+		return err
+	}
+	size, err := bf.signedSize()
+	if err != nil {
+		return err
+	}
+	if m.Size != size {
+		return jengaerr.SignatureVerifyError.Format("data size not match")
+	}
+	digest, err := bf.digest(size)
+	if err != nil {
+		return err
+	}
+	if digest != m.Digest {
+		return jengaerr.SignatureVerifyError.Format("digest not match")
+	}
+	root, err := bf.signedRoot()
+	if err != nil {
+		return err
+	}
+	if root != m.Root {
+		return jengaerr.SignatureVerifyError.Format("merkle root not match")
+	}
+	return nil
+}
+
+// 签名的Merkle根，不包含Merkle树（V2格式）时为全0
+func (bf *BlkFileV2) signedRoot() ([sha256.Size]byte, error) {
+	var ret [sha256.Size]byte
+	if !bf.hasTrailer() || bf.index == nil {
+		return ret, nil
+	}
+	root, err := bf.MerkleRoot()
+	if err != nil {
+		return ret, err
+	}
+	copy(ret[:], root)
+	return ret, nil
+}
+
+// 打开时校验签名（WithVerifyKey）
+func (bf *BlkFileV2) checkSignature() error {
+	if bf.verifyKey == nil {
+		return nil
+	}
+	var m *Manifest
+	if bf.detachedSig != nil {
+		var err error
+		m, err = ParseManifest(bf.detachedSig)
+		if err != nil {
+			return err
+		}
+	}
+	err := bf.Verify(bf.verifyKey, m)
+	if err != nil || !bf.hasTrailer() {
+		return err
+	}
+	cur := bf.cur
+	bf.index = nil
+	err = bf.rebuildIndex()
+	if err != nil {
+		return err
+	}
+	if bf.flag.CanWrite() {
+		return bf.seek(cur)
+	}
+	return bf.seek(bf.start)
+}
+
+// 签名数据的大小：写入模式下为当前写入位置（尾部索引已移除），否则为尾部索引的位置或文件大小
+func (bf *BlkFileV2) signedSize() (int64, error) {
+	if bf.flag.CanWrite() {
+		return bf.cur, nil
+	}
+	if bf.trailerOff > 0 {
+		return bf.trailerOff, nil
+	}
+	cur, err := bf.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := bf.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = bf.file.Seek(cur, io.SeekStart)
+	return end, err
+}
+
+// 计算文件开头size字节的SHA-256摘要，不影响当前读写位置
+func (bf *BlkFileV2) digest(size int64) ([sha256.Size]byte, error) {
+	var ret [sha256.Size]byte
+	h := sha256.New()
+	n, err := io.Copy(h, io.NewSectionReader(bf.readerAt, 0, size))
+	if err != nil {
+		return ret, err
+	}
+	if n != size {
+		return ret, io.ErrUnexpectedEOF
+	}
+	copy(ret[:], h.Sum(nil))
+	return ret, nil
+}
@@ -0,0 +1,434 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jengablk
+
+import (
+	"bufio"
+	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/flags"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+	"math"
+)
+
+// Entity format(FeatureStream，数据写入完成后才能获得大小，实体头位于数据之后):
+// |VARINT(1-10 Bytes)|STRING(string length)|[COMPRESS TYPE(2 Bytes)]|[VARINT(meta size)|META]|VARINT(chunk size)|CHUNK(chunk size)|...|VARINT(0)|DATA SIZE(8 Bytes)|[ORIGIN SIZE(8 Bytes)]|[CRC32C(4 Bytes)]|
+// FeatureEncrypt时CHUNK为加密后的数据按BlkFileBufferSize分块，与加密分块无关
+// Tombstone entity: 不包含CHUNK，DATA SIZE为BlkTombstoneSize
+//
+// 写入过程中无需Seek，可写入管道、标准输出、网络连接等不支持Seek的io.Writer。
+
+// 新建文件时使用分块格式写入实体，不依赖Seek
+func (bf *BlkFileV2) WithStream() *BlkFileV2 {
+	bf.features |= FeatureStream
+	return bf
+}
+
+func (bf *BlkFileV2) isStream() bool {
+	return bf.header.HasFeature(FeatureStream)
+}
+
+func (bf *BlkFileV2) writeStreamBlock(key string, meta *EntryMeta, c compressor.Compressor, reader io.Reader, raw bool) (*blkNode, error) {
+	start := bf.cur
+	err := bf.writeKey(key, c.Type().Value())
+	if err != nil {
+		return nil, err
+	}
+	if bf.header.HasFeature(FeatureMeta) {
+		n, err := writeEntryMeta(bf.file, meta)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		meta = nil
+	}
+	node := &blkNode{
+		key:      key,
+		offset:   bf.cur,
+		meta:     meta,
+		compress: c.Type().Value(),
+	}
+	c, reader, sum := bf.hashEntity(c, reader, raw)
+	cw := newChunkWriter(bf.file)
+	originWn, _, err := bf.compressTo(cw, c, reader, key)
+	if err == nil {
+		err = cw.Close()
+	}
+	bf.cur += cw.written
+	node.size = cw.size
+	node.originSize = originWn
+	if err != nil {
+		return node, err
+	}
+	sum(node)
+	wn, err := bf.file.Write(bf.entityHead(uint64(node.size), node.originSize, node.checksum))
+	bf.cur += int64(wn)
+	if err != nil {
+		return node, err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return node, err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, node)
+	}
+	return node, nil
+}
+
+// 将src中node的数据（已压缩）按分块格式写入
+func (bf *BlkFileV2) writeStreamRawBlock(src *BlkFileV2, node *blkNode) (*blkNode, error) {
+	node, err := bf.rawNode(src, node)
+	if err != nil {
+		return nil, err
+	}
+	start := bf.cur
+	err = bf.writeKey(node.key, node.compress)
+	if err != nil {
+		return nil, err
+	}
+	var meta *EntryMeta
+	if bf.header.HasFeature(FeatureMeta) {
+		meta = node.meta
+		n, err := writeEntryMeta(bf.file, meta)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+	}
+	ret := &blkNode{
+		key:        node.key,
+		originSize: node.originSize,
+		offset:     bf.cur,
+		checksum:   node.checksum,
+		meta:       meta,
+		compress:   node.compress,
+		digest:     node.digest,
+	}
+	cw := newChunkWriter(bf.file)
+	_, err = io.Copy(cw, src.payload(node))
+	if err == nil {
+		err = cw.Close()
+	}
+	bf.cur += cw.written
+	ret.size = cw.size
+	if err != nil {
+		return ret, err
+	}
+	if ret.size != node.size {
+		return ret, jengaerr.ReadNodeSizeNotMatchError
+	}
+	wn, err := bf.file.Write(bf.entityHead(uint64(ret.size), ret.originSize, ret.checksum))
+	bf.cur += int64(wn)
+	if err != nil {
+		return ret, err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return ret, err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, ret)
+	}
+	return ret, nil
+}
+
+func (bf *BlkFileV2) writeStreamTombstone(key string) error {
+	start := bf.cur
+	err := bf.writeKey(key, bf.compressor.Type().Value())
+	if err != nil {
+		return err
+	}
+	if bf.header.HasFeature(FeatureMeta) {
+		n, err := writeEntryMeta(bf.file, nil)
+		bf.cur += n
+		if err != nil {
+			return err
+		}
+	}
+	err = writeVarint(bf.file, 0)
+	if err != nil {
+		return err
+	}
+	bf.cur++
+	wn, err := bf.file.Write(bf.entityHead(BlkTombstoneSize, 0, 0))
+	bf.cur += int64(wn)
+	if err != nil {
+		return err
+	}
+	err = bf.writeCommit(start)
+	if err != nil {
+		return err
+	}
+	if bf.index != nil {
+		bf.index = append(bf.index, &blkNode{
+			key:      key,
+			offset:   bf.cur,
+			deleted:  true,
+			compress: bf.compressor.Type().Value(),
+		})
+	}
+	return nil
+}
+
+// 读取key之后的实体数据，w不为nil时解压数据至w
+func (bf *BlkFileV2) readStreamBlock(w io.Writer, node *blkNode) (*blkNode, error) {
+	if bf.header.HasFeature(FeatureMeta) {
+		meta, n, err := readEntryMeta(bf.file)
+		bf.cur += n
+		if err != nil {
+			return nil, err
+		}
+		node.meta = meta
+	}
+	node.offset = bf.current()
+	var size int64
+	for {
+		v, n, err := readVarint(bf.file)
+		bf.cur += int64(n)
+		if err != nil {
+			return nil, err
+		}
+		if v == 0 {
+			break
+		}
+		if v > math.MaxInt64-uint64(bf.cur) {
+			return nil, jengaerr.JengaBrokenError
+		}
+		err = bf.seek(bf.cur + int64(v))
+		if err != nil {
+			return nil, err
+		}
+		size += int64(v)
+	}
+	v, err := bf.readPayloadSize()
+	if err != nil {
+		return nil, err
+	}
+	if bf.header.HasFeature(FeatureOriginSize) {
+		node.originSize, err = bf.readPayloadSize()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		node.originSize = BlkHeaderUnknownSize
+	}
+	if bf.header.HasFeature(FeatureChecksum) {
+		node.checksum, err = bf.readChecksum()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if uint64(v) == BlkTombstoneSize {
+		node.meta = nil
+		node.deleted = true
+		return node, nil
+	}
+	if v != size {
+		return nil, jengaerr.ReadNodeSizeNotMatchError
+	}
+	node.size = size
+	if w != nil {
+		n, originSize, err := bf.decompressFrom(w, bf.payload(node), node)
+		if err != nil {
+			return nil, err
+		}
+		if n != node.size {
+			return nil, jengaerr.ReadNodeSizeNotMatchError
+		}
+		node.originSize = originSize
+	}
+	return node, nil
+}
+
+// 获得node压缩数据的reader，不影响当前读写位置
+func (bf *BlkFileV2) payload(node *blkNode) io.Reader {
+	if bf.isStream() {
+		r := io.NewSectionReader(bf.readerAt, node.offset, math.MaxInt64-node.offset)
+		return newChunkReader(bufio.NewReaderSize(r, BlkFileBufferSize))
+	}
+	return bf.section(node)
+}
+
+// 将数据按|VARINT(chunk size)|CHUNK|格式写入，Close时写入VARINT(0)表示结束
+type chunkWriter struct {
+	w   io.Writer
+	buf []byte
+	n   int
+	// 已写入的数据大小（不包含分块头）
+	size int64
+	// 已写入的全部数据大小
+	written int64
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:   w,
+		buf: make([]byte, BlkFileBufferSize),
+	}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		total += n
+		p = p[n:]
+		if w.n == len(w.buf) {
+			err := w.flush()
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if w.n == 0 {
+		return nil
+	}
+	err := w.writeSize(uint64(w.n))
+	if err != nil {
+		return err
+	}
+	n, err := w.w.Write(w.buf[:w.n])
+	w.written += int64(n)
+	w.size += int64(n)
+	w.n = 0
+	return err
+}
+
+func (w *chunkWriter) writeSize(size uint64) error {
+	vi := VarInt{}
+	vi.InitFromUInt64(size)
+	n, err := w.w.Write(vi.Bytes())
+	w.written += int64(n)
+	return err
+}
+
+func (w *chunkWriter) Close() error {
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+	return w.writeSize(0)
+}
+
+// 读取chunkWriter写入的数据，读取到VARINT(0)时返回io.EOF
+type chunkReader struct {
+	r    io.Reader
+	left uint64
+	eof  bool
+	// 已读取的数据大小（不包含分块头）
+	size int64
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r: r,
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	for r.left == 0 {
+		size, _, err := readVarint(r.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if size == 0 {
+			r.eof = true
+			return 0, io.EOF
+		}
+		r.left = size
+	}
+	if uint64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= uint64(n)
+	r.size += int64(n)
+	if err == io.EOF {
+		if n > 0 {
+			err = nil
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+// 将io.Writer适配为BlockReadWriter，仅支持顺序写入
+type streamWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+func (w *streamWriter) Read(p []byte) (int, error) {
+	return 0, jengaerr.NotSupportError.Format("Stream writer", "Read")
+}
+
+// 仅支持获得当前位置
+func (w *streamWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		if offset == w.offset {
+			return w.offset, nil
+		}
+	case io.SeekCurrent, io.SeekEnd:
+		if offset == 0 {
+			return w.offset, nil
+		}
+	}
+	return w.offset, jengaerr.NotSupportError.Format("Stream writer", "Seek")
+}
+
+type flusher interface {
+	Flush() error
+}
+
+func (w *streamWriter) Sync() error {
+	if f, ok := w.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// 不关闭w，由调用者关闭
+func (w *streamWriter) Close() error {
+	return w.Sync()
+}
+
+// 写入w，只能以OpFlagWriteOnly|OpFlagCreate打开一次，w由调用者关闭
+func (o blkFileV2Openers) Writer(w io.Writer) Opener {
+	opened := false
+	return func(flag flags.OpenFlag) (BlockReadWriter, bool, error) {
+		if flag.CanRead() || !flag.CanWrite() || !flag.NeedCreate() {
+			return nil, false, jengaerr.OpenFlagError.Format(flag)
+		}
+		if opened {
+			return nil, false, jengaerr.NotSupportError.Format("Stream writer", "reopen")
+		}
+		opened = true
+		return &streamWriter{
+			w: w,
+		}, true, nil
+	}
+}
@@ -57,7 +57,7 @@ func (v *VarInt) InitFromUInt64(x uint64) {
 
 func (v *VarInt) LoadFromReader(r io.Reader) (bool, int, error) {
     size := 0
-    for {
+    for v.cur < MaxVarUintBufSize {
         n, err := r.Read(v.data[v.cur : v.cur+1])
         if err != nil {
             return false, n, err
@@ -0,0 +1,276 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TypeAdaptive = 9
+
+	// 默认采样大小
+	DefaultAdaptiveSampleSize = 64 * 1024
+	// 默认最小压缩比（原始大小/压缩后大小），采样数据的压缩比低于时不压缩
+	DefaultAdaptiveMinRatio = 1.1
+
+	adaptiveRaw        byte = 0
+	adaptiveCompressed byte = 1
+)
+
+// 自适应压缩的统计
+type AdaptiveStats struct {
+	// 压缩保存的数据个数
+	Compressed int64
+	// 不压缩直接保存的数据个数
+	Raw int64
+	// 不压缩直接保存的数据大小
+	RawSize int64
+}
+
+// 提供自适应压缩统计的压缩器（如BlockV2Opts.WithAdaptive创建的压缩器）
+type AdaptiveStatsCompressor interface {
+	Compressor
+
+	// 获得自适应压缩的统计
+	Stats() AdaptiveStats
+}
+
+// 采样数据压缩后的缓存，数据不超过采样大小时直接写入
+var adaptiveBuffers = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// 压缩前采样估算压缩比，压缩比过低（如jpg、zip等已压缩的数据）时不压缩直接保存。
+// 数据格式：|MODE(1 Byte)|DATA| 或 |MODE(1 Byte)|COMPRESS TYPE(2 Bytes)|DATA(compressed)|
+// 解压时根据记录的压缩类型选择压缩器，与当前使用的压缩器不同时从注册表创建
+type adaptiveCompressor struct {
+	inner      Compressor
+	sampleSize int
+	minRatio   float64
+	buf        *bufferPool
+	sample     *bufferPool
+	stats      *AdaptiveStats
+}
+
+type AdaptiveOpt func(c *adaptiveCompressor)
+
+func NewAdaptiveCompressor(inner Compressor, opts ...AdaptiveOpt) *adaptiveCompressor {
+	ret := &adaptiveCompressor{
+		inner:      inner,
+		sampleSize: DefaultAdaptiveSampleSize,
+		minRatio:   DefaultAdaptiveMinRatio,
+		stats:      &AdaptiveStats{},
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	ret.sample = newBufferPool(ret.sampleSize)
+	return ret
+}
+
+// 压缩类型
+func (c *adaptiveCompressor) Type() Type {
+	return TypeAdaptive
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *adaptiveCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	sample := c.sample.get()
+	defer c.sample.put(sample)
+	n, err := io.ReadFull(srcReader, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return int64(n), 0, err
+	}
+	eof := n < len(sample)
+	src := io.MultiReader(bytes.NewReader(sample[:n]), srcReader)
+	w := NewSizeWriter(dstWriter)
+	cb := adaptiveBuffers.Get().(*bytes.Buffer)
+	defer adaptiveBuffers.Put(cb)
+	if !c.compressible(sample[:n], cb) {
+		_, err = w.Write([]byte{adaptiveRaw})
+		if err != nil {
+			return 0, w.Size(), err
+		}
+		buf := c.buf.get()
+		defer c.buf.put(buf)
+		before, err = io.CopyBuffer(w, src, buf)
+		atomic.AddInt64(&c.stats.Raw, 1)
+		atomic.AddInt64(&c.stats.RawSize, before)
+		return before, w.Size(), err
+	}
+	head := []byte{adaptiveCompressed, 0, 0}
+	binary.BigEndian.PutUint16(head[1:], c.inner.Type().Value())
+	_, err = w.Write(head)
+	if err != nil {
+		return 0, w.Size(), err
+	}
+	if eof {
+		// 采样包含全部数据，直接使用采样的压缩结果
+		_, err = w.Write(cb.Bytes())
+		before = int64(n)
+	} else {
+		before, _, err = c.inner.Compress(w, src)
+	}
+	atomic.AddInt64(&c.stats.Compressed, 1)
+	return before, w.Size(), err
+}
+
+// 将采样数据压缩至b，压缩比不低于minRatio时返回true
+func (c *adaptiveCompressor) compressible(sample []byte, b *bytes.Buffer) bool {
+	b.Reset()
+	if len(sample) == 0 {
+		return false
+	}
+	_, _, err := c.inner.Compress(b, bytes.NewReader(sample))
+	if err != nil || b.Len() == 0 {
+		return false
+	}
+	return float64(len(sample))/float64(b.Len()) >= c.minRatio
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *adaptiveCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	defer func() {
+		before = r.Size()
+	}()
+	head := make([]byte, 3)
+	_, err = io.ReadFull(r, head[:1])
+	if err != nil {
+		return 0, 0, unexpectedEOF(err)
+	}
+	switch head[0] {
+	case adaptiveRaw:
+		buf := c.buf.get()
+		defer c.buf.put(buf)
+		after, err = io.CopyBuffer(dstWriter, r, buf)
+		return
+	case adaptiveCompressed:
+		_, err = io.ReadFull(r, head[1:])
+		if err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		z, err := c.compressorOf(binary.BigEndian.Uint16(head[1:]))
+		if err != nil {
+			return 0, 0, err
+		}
+		_, after, err = z.Decompress(dstWriter, r)
+		return 0, after, err
+	}
+	return 0, 0, jengaerr.DataFormatNotSupportError.Format(head[0])
+}
+
+func (c *adaptiveCompressor) compressorOf(t uint16) (Compressor, error) {
+	if c.inner.Type().Value() == t {
+		return c.inner, nil
+	}
+	if z, ok := NewCompressor(t); ok {
+		return z, nil
+	}
+	return nil, jengaerr.DataFormatNotSupportError.Format(t)
+}
+
+// 获得自适应压缩的统计，使用相同配置（如WithDict）创建的压缩器共享统计
+func (c *adaptiveCompressor) Stats() AdaptiveStats {
+	return AdaptiveStats{
+		Compressed: atomic.LoadInt64(&c.stats.Compressed),
+		Raw:        atomic.LoadInt64(&c.stats.Raw),
+		RawSize:    atomic.LoadInt64(&c.stats.RawSize),
+	}
+}
+
+// 获得实际使用的压缩器
+func (c *adaptiveCompressor) Inner() Compressor {
+	return c.inner
+}
+
+// 获得实际使用的压缩器的字典，未使用字典时返回nil
+func (c *adaptiveCompressor) Dict() []byte {
+	if dc, ok := c.inner.(DictCompressor); ok {
+		return dc.Dict()
+	}
+	return nil
+}
+
+// 使用字典创建新的压缩器，实际使用的压缩器需支持字典
+func (c *adaptiveCompressor) WithDict(dict []byte) (Compressor, error) {
+	ret := *c
+	if dc, ok := c.inner.(DictCompressor); ok {
+		inner, err := dc.WithDict(dict)
+		if err != nil {
+			return nil, err
+		}
+		ret.inner = inner
+	} else if len(dict) > 0 {
+		return nil, jengaerr.NotSupportError.Format(GetName(c.inner.Type().Value()), "dictionary")
+	}
+	return &ret, nil
+}
+
+// 实际使用的压缩器只能解压时不能写入
+func (c *adaptiveCompressor) ReadOnly() bool {
+	return IsReadOnly(c.inner)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type adaptiveOpts struct{}
+
+var AdaptiveOpts adaptiveOpts
+
+func (opt adaptiveOpts) WithBuffer(buf []byte) AdaptiveOpt {
+	return func(c *adaptiveCompressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt adaptiveOpts) BufferSize(size int) AdaptiveOpt {
+	return func(c *adaptiveCompressor) {
+		c.buf = newBufferPool(size)
+	}
+}
+
+// 采样大小，默认为DefaultAdaptiveSampleSize
+func (opt adaptiveOpts) SampleSize(size int) AdaptiveOpt {
+	return func(c *adaptiveCompressor) {
+		if size > 0 {
+			c.sampleSize = size
+		}
+	}
+}
+
+// 最小压缩比（原始大小/压缩后大小），默认为DefaultAdaptiveMinRatio
+func (opt adaptiveOpts) MinRatio(ratio float64) AdaptiveOpt {
+	return func(c *adaptiveCompressor) {
+		c.minRatio = ratio
+	}
+}
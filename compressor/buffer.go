@@ -0,0 +1,41 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import "sync"
+
+// 复制数据使用的缓存池，使Compressor可被多个goroutine同时使用
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	ret := &bufferPool{}
+	ret.pool.New = func() interface{} {
+		return make([]byte, size)
+	}
+	return ret
+}
+
+// 使用指定的缓存创建缓存池，缓存不足时按相同大小分配
+func newBufferPoolWith(buf []byte) *bufferPool {
+	ret := newBufferPool(len(buf))
+	if len(buf) > 0 {
+		ret.put(buf)
+	}
+	return ret
+}
+
+func (p *bufferPool) get() []byte {
+	return p.pool.Get().([]byte)
+}
+
+func (p *bufferPool) put(buf []byte) {
+	p.pool.Put(buf)
+}
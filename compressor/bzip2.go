@@ -0,0 +1,83 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"compress/bzip2"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+)
+
+const (
+	TypeBzip2 = 8
+)
+
+// bzip2只能解压，用于读取其他工具生成的数据
+type bzip2Compressor struct {
+	buf *bufferPool
+}
+
+type Bzip2Opt func(c *bzip2Compressor)
+
+func NewBzip2Compressor(opts ...Bzip2Opt) *bzip2Compressor {
+	ret := &bzip2Compressor{}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	return ret
+}
+
+// 压缩类型
+func (c *bzip2Compressor) Type() Type {
+	return TypeBzip2
+}
+
+// 不支持压缩，返回CompressorReadOnlyError
+func (c *bzip2Compressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	return 0, 0, jengaerr.CompressorReadOnlyError.Format("bzip2")
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *bzip2Compressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	z := bzip2.NewReader(r)
+	defer func() {
+		before = r.Size()
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z, buf)
+	return
+}
+
+// 只能解压
+func (c *bzip2Compressor) ReadOnly() bool {
+	return true
+}
+
+type bzip2Opts struct{}
+
+var Bzip2Opts bzip2Opts
+
+func (opt bzip2Opts) WithBuffer(buf []byte) Bzip2Opt {
+	return func(c *bzip2Compressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt bzip2Opts) BufferSize(size int) Bzip2Opt {
+	return func(c *bzip2Compressor) {
+		c.buf = newBufferPool(size)
+	}
+}
@@ -30,6 +30,33 @@ type Compressor interface {
 	Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error)
 }
 
+// 支持字典的压缩器。字典保存于jenga文件中，读取时通过WithDict设置
+type DictCompressor interface {
+	Compressor
+
+	// 获得字典，未使用字典时返回nil
+	Dict() []byte
+
+	// 使用字典创建新的压缩器
+	WithDict(dict []byte) (Compressor, error)
+}
+
+// 只能解压的压缩器（如bzip2），用于读取已有数据，Compress返回CompressorReadOnlyError
+type ReadOnlyCompressor interface {
+	Compressor
+
+	// 是否只能解压
+	ReadOnly() bool
+}
+
+// 压缩器是否只能解压
+func IsReadOnly(c Compressor) bool {
+	if r, ok := c.(ReadOnlyCompressor); ok {
+		return r.ReadOnly()
+	}
+	return false
+}
+
 func (t Type) Value() uint16 {
 	return uint16(t)
 }
@@ -7,7 +7,18 @@ package compressor
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -53,3 +64,533 @@ func TestZlib(t *testing.T) {
 	t.Log(s.String())
 }
 
+
+func TestZlibLevel(t *testing.T) {
+	data := benchCorpus()
+	var sizes []int
+	for _, level := range []GzipCompressLevel{NoCompression, BestSpeed, BestCompression} {
+		z := NewZlibCompressor(ZlibOpts.Level(level))
+		b := bytes.NewBuffer(nil)
+		_, _, err := z.Compress(b, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, b.Len())
+		s := bytes.NewBuffer(nil)
+		_, _, err = z.Decompress(s, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(s.Bytes(), data) {
+			t.Fatal("data not match")
+		}
+	}
+	t.Log(sizes)
+	if sizes[0] <= len(data) || sizes[1] <= sizes[2] {
+		t.Fatal("size not match level: ", sizes)
+	}
+	z := NewZlibCompressor(ZlibOpts.Level(GzipCompressLevel(10)))
+	_, _, err := z.Compress(ioutil.Discard, strings.NewReader("level"))
+	if err == nil {
+		t.Fatal("expect invalid level error")
+	}
+}
+
+// 复用的压缩状态可被多个goroutine同时使用，解压失败后仍可继续使用
+func TestPooledState(t *testing.T) {
+	for _, z := range []Compressor{NewGzipCompressor(), NewZlibCompressor()} {
+		_, _, err := z.Decompress(ioutil.Discard, strings.NewReader("not compressed"))
+		if err == nil {
+			t.Fatal("expect error")
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					data := strings.Repeat(fmt.Sprintf("pooled %d-%d|", i, j), 100+j)
+					b := bytes.NewBuffer(nil)
+					_, _, err := z.Compress(b, strings.NewReader(data))
+					if err != nil {
+						errs <- err
+						return
+					}
+					s := &strings.Builder{}
+					_, _, err = z.Decompress(s, b)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if s.String() != data {
+						errs <- fmt.Errorf("data not match: %d-%d", i, j)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(GetName(z.Type().Value()), err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	for _, name := range []string{"gzip", "zlib"} {
+		v, ok := GetType(name)
+		if !ok {
+			t.Fatal("expect registered: ", name)
+		}
+		c, ok := NewCompressor(v)
+		if !ok || c.Type().Value() != v {
+			t.Fatal("compressor not match: ", name)
+		}
+	}
+	if Register(TypeGzip, "gzip2", func() Compressor { return NewGzipCompressor() }) {
+		t.Fatal("expect register failed")
+	}
+	if GetName(TypeGzip) != "gzip" {
+		t.Fatal("name not match: ", GetName(TypeGzip))
+	}
+	if _, ok := NewCompressor(0xFFF0); ok {
+		t.Fatal("expect not registered")
+	}
+	if !Register(0xFFF0, "test", func() Compressor { return NewBufferCompressor(0) }) {
+		t.Fatal("expect register success")
+	}
+	if v, ok := GetType("test"); !ok || v != 0xFFF0 {
+		t.Fatal("type not match: ", v)
+	}
+}
+
+func TestZstd(t *testing.T) {
+	data := strings.Repeat("hello zstd ", 100)
+	for _, z := range []*zstdCompressor{
+		NewZstdCompressor(),
+		NewZstdCompressor(ZstdOpts.Level(ZstdSpeedFastest)),
+		NewZstdCompressor(ZstdOpts.Level(ZstdLevel(19))),
+	} {
+		b := bytes.NewBuffer(nil)
+		n1, n2, err := z.Compress(b, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n1 != int64(len(data)) || n2 != int64(b.Len()) {
+			t.Fatal("size not match: ", n1, n2)
+		}
+		s := &strings.Builder{}
+		n1, n2, err = z.Decompress(s, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.String() != data || n2 != int64(len(data)) {
+			t.Fatal("data not match")
+		}
+	}
+	_, err := NewZstdCompressor().WithDict([]byte("not a dictionary"))
+	if err == nil {
+		t.Fatal("expect dictionary error")
+	}
+}
+
+// 不同大小的数据分别一次压缩或流式压缩，复用的Encoder、Decoder可同时使用
+func TestZstdReuse(t *testing.T) {
+	corpus := benchCorpus()
+	large := bytes.Repeat(corpus, 8)
+	z := NewZstdCompressor()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, data := range [][]byte{nil, corpus[:100], corpus[:64*1024], corpus, large} {
+				b := bytes.NewBuffer(nil)
+				n1, n2, err := z.Compress(b, bytes.NewReader(data))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n1 != int64(len(data)) || n2 != int64(b.Len()) {
+					t.Error("size not match: ", n1, n2)
+					return
+				}
+				size := int64(b.Len())
+				buf := bytes.NewBuffer(nil)
+				n1, n2, err = z.Decompress(buf, b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(buf.Bytes(), data) || n1 != size || n2 != int64(len(data)) {
+					t.Error("data not match: ", len(data))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 流式写入（不包含原始数据大小）的数据
+	b := bytes.NewBuffer(nil)
+	w, err := zstd.NewWriter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write(corpus[:100])
+	_ = w.Close()
+	buf := bytes.NewBuffer(nil)
+	_, _, err = z.Decompress(buf, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), corpus[:100]) {
+		t.Fatal("data not match")
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	data := string(benchCorpus())
+	for _, z := range []Compressor{
+		NewLz4Compressor(),
+		NewLz4Compressor(Lz4Opts.Level(Lz4Level9)),
+		NewSnappyCompressor(),
+		NewFlateCompressor(),
+		NewFlateCompressor(FlateOpts.Level(BestCompression), FlateOpts.Dict([]byte(`{"id":,"level":"info","message":"request finished"}`))),
+		NewLzwCompressor(),
+		NewLzwCompressor(LzwOpts.Order(LzwMSB)),
+	} {
+		// 第二次使用复用的writer、reader
+		for i := 0; i < 2; i++ {
+			b := bytes.NewBuffer(nil)
+			n1, n2, err := z.Compress(b, strings.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n1 != int64(len(data)) || n2 != int64(b.Len()) {
+				t.Fatal("size not match: ", n1, n2)
+			}
+			t.Log(GetName(z.Type().Value()), " ", n1, " ", n2)
+			size := int64(b.Len())
+			s := &strings.Builder{}
+			n1, n2, err = z.Decompress(s, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.String() != data || n1 != size || n2 != int64(len(data)) {
+				t.Fatal("data not match")
+			}
+		}
+	}
+}
+
+func TestBzip2(t *testing.T) {
+	z := NewBzip2Compressor()
+	if !IsReadOnly(z) || IsReadOnly(NewGzipCompressor()) {
+		t.Fatal("read only not match")
+	}
+	_, _, err := z.Compress(ioutil.Discard, strings.NewReader("bzip2"))
+	if !jengaerr.CompressorReadOnlyError.Equal(err) {
+		t.Fatal("expect read only error but get ", err)
+	}
+	d, err := ioutil.ReadFile("../test/test.json.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadFile("../test/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &strings.Builder{}
+	n1, n2, err := z.Decompress(s, bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != string(expect) || n1 != int64(len(d)) || n2 != int64(len(expect)) {
+		t.Fatal("data not match: ", n1, n2)
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	text := benchCorpus()
+	random := make([]byte, 128*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	z := NewAdaptiveCompressor(NewGzipCompressor(), AdaptiveOpts.SampleSize(16*1024))
+	for _, data := range [][]byte{text, random, nil} {
+		b := bytes.NewBuffer(nil)
+		n1, n2, err := z.Compress(b, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n1 != int64(len(data)) || n2 != int64(b.Len()) {
+			t.Fatal("size not match: ", n1, n2)
+		}
+		payload := b.Bytes()
+		if len(data) == len(text) && (payload[0] != adaptiveCompressed || len(payload) >= len(data)) {
+			t.Fatal("expect compressed: ", len(payload))
+		}
+		if len(data) != len(text) && (payload[0] != adaptiveRaw || len(payload) != len(data)+1) {
+			t.Fatal("expect raw: ", len(payload))
+		}
+		// 使用不同压缩器的自适应压缩器解压
+		for _, d := range []Compressor{z, NewAdaptiveCompressor(NewZlibCompressor())} {
+			buf := bytes.NewBuffer(nil)
+			n1, n2, err = d.Decompress(buf, bytes.NewReader(payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) || n1 != int64(len(payload)) || n2 != int64(len(data)) {
+				t.Fatal("data not match")
+			}
+		}
+	}
+	stats := z.Stats()
+	if stats.Compressed != 1 || stats.Raw != 2 || stats.RawSize != int64(len(random)) {
+		t.Fatal("stats not match: ", stats)
+	}
+
+	_, _, err := z.Decompress(ioutil.Discard, bytes.NewReader([]byte{adaptiveCompressed, 0xFF, 0xFF}))
+	if !jengaerr.DataFormatNotSupportError.Equal(err) {
+		t.Fatal("expect data format not support error but get ", err)
+	}
+	_, err = z.WithDict([]byte("dict"))
+	if !jengaerr.NotSupportError.Equal(err) {
+		t.Fatal("expect not support error but get ", err)
+	}
+	dz, err := NewAdaptiveCompressor(NewFlateCompressor()).WithDict([]byte("dict"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dz.(DictCompressor).Dict()) != "dict" {
+		t.Fatal("dict not match")
+	}
+	if !IsReadOnly(NewAdaptiveCompressor(NewBzip2Compressor())) {
+		t.Fatal("expect read only")
+	}
+}
+
+// 记录Compress调用次数
+type countCompressor struct {
+	Compressor
+	count int
+}
+
+func (c *countCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (int64, int64, error) {
+	c.count++
+	return c.Compressor.Compress(dstWriter, srcReader)
+}
+
+func TestAdaptiveSample(t *testing.T) {
+	text := benchCorpus()
+	for _, size := range []int{4 * 1024, 16 * 1024, len(text)} {
+		inner := &countCompressor{Compressor: NewGzipCompressor()}
+		var z AdaptiveStatsCompressor = NewAdaptiveCompressor(inner, AdaptiveOpts.SampleSize(16*1024))
+		b := bytes.NewBuffer(nil)
+		_, _, err := z.Compress(b, bytes.NewReader(text[:size]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 数据小于采样大小时复用采样的压缩结果
+		expect := 2
+		if size < 16*1024 {
+			expect = 1
+		}
+		if inner.count != expect {
+			t.Fatal("compress count not match: ", size, inner.count)
+		}
+		buf := bytes.NewBuffer(nil)
+		_, _, err = z.Decompress(buf, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), text[:size]) || z.Stats().Compressed != 1 {
+			t.Fatal("data not match")
+		}
+	}
+}
+
+// 模拟JSON日志的测试数据
+func benchCorpus() []byte {
+	r := rand.New(rand.NewSource(1))
+	levels := []string{"debug", "info", "warn", "error"}
+	buf := bytes.NewBuffer(nil)
+	for buf.Len() < 256*1024 {
+		fmt.Fprintf(buf, `{"id":%d,"level":"%s","user":"user%d","latency_ms":%d,"message":"request %x finished"}`+"\n",
+			r.Int63(), levels[r.Intn(len(levels))], r.Intn(10000), r.Intn(1000), r.Int63())
+	}
+	return buf.Bytes()
+}
+
+func benchCompressors() []Compressor {
+	return []Compressor{
+		NewGzipCompressor(),
+		NewZlibCompressor(),
+		NewZstdCompressor(),
+		NewLz4Compressor(),
+		NewSnappyCompressor(),
+		NewFlateCompressor(),
+		NewLzwCompressor(),
+		NewAdaptiveCompressor(NewGzipCompressor()),
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	data := benchCorpus()
+	for _, z := range benchCompressors() {
+		b.Run(GetName(z.Type().Value()), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			var size int64
+			for i := 0; i < b.N; i++ {
+				_, n, err := z.Compress(ioutil.Discard, bytes.NewReader(data))
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = n
+			}
+			b.ReportMetric(float64(size)/float64(len(data)), "ratio")
+		})
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	data := benchCorpus()
+	for _, z := range benchCompressors() {
+		b.Run(GetName(z.Type().Value()), func(b *testing.B) {
+			buf := bytes.NewBuffer(nil)
+			_, _, err := z.Compress(buf, bytes.NewReader(data))
+			if err != nil {
+				b.Fatal(err)
+			}
+			compressed := buf.Bytes()
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, err := z.Decompress(ioutil.Discard, bytes.NewReader(compressed))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// 大量小数据时复用压缩状态与每次新建的对比：
+// go test ./compressor -run none -bench SmallBlock -benchmem
+func BenchmarkSmallBlockCompress(b *testing.B) {
+	data := benchCorpus()[:1024]
+	for name, z := range map[string]Compressor{
+		"gzip/new":    newWriterCompressor(func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriterLevel(w, gzip.DefaultCompression) }),
+		"gzip/pooled": NewGzipCompressor(),
+		"zlib/new":    newWriterCompressor(func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, zlib.DefaultCompression) }),
+		"zlib/pooled": NewZlibCompressor(),
+		"zstd/new": newWriterCompressor(func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		}),
+		"zstd/pooled": NewZstdCompressor(),
+		"lz4/new": newWriterCompressor(func(w io.Writer) (io.WriteCloser, error) {
+			return lz4.NewWriter(w), nil
+		}),
+		"lz4/pooled": NewLz4Compressor(),
+		"snappy/new": newWriterCompressor(func(w io.Writer) (io.WriteCloser, error) {
+			return snappy.NewBufferedWriter(w), nil
+		}),
+		"snappy/pooled": NewSnappyCompressor(),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _, err := z.Compress(ioutil.Discard, bytes.NewReader(data))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSmallBlockDecompress(b *testing.B) {
+	data := benchCorpus()[:1024]
+	for name, z := range map[string]struct {
+		c      Compressor
+		create func(r io.Reader) (io.ReadCloser, error)
+	}{
+		"gzip": {NewGzipCompressor(), func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }},
+		"zlib": {NewZlibCompressor(), zlib.NewReader},
+		"zstd": {NewZstdCompressor(), func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}},
+		"lz4": {NewLz4Compressor(), func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(lz4.NewReader(r)), nil
+		}},
+		"snappy": {NewSnappyCompressor(), func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(snappy.NewReader(r)), nil
+		}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		_, _, err := z.c.Compress(buf, bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		compressed := buf.Bytes()
+		b.Run(name+"/new", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r, err := z.create(bytes.NewReader(compressed))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = io.Copy(ioutil.Discard, r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = r.Close()
+			}
+		})
+		b.Run(name+"/pooled", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _, err := z.c.Decompress(ioutil.Discard, bytes.NewReader(compressed))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// 每次压缩新建writer，用于对比
+type writerCompressor struct {
+	bufferCompressor
+	create func(w io.Writer) (io.WriteCloser, error)
+}
+
+func newWriterCompressor(create func(w io.Writer) (io.WriteCloser, error)) *writerCompressor {
+	return &writerCompressor{
+		bufferCompressor: *NewBufferCompressor(DefaultBufferSize),
+		create:           create,
+	}
+}
+
+func (c *writerCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (int64, int64, error) {
+	w := NewSizeWriter(dstWriter)
+	z, err := c.create(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := io.Copy(z, srcReader)
+	if e := z.Close(); e != nil && err == nil {
+		err = e
+	}
+	return n, w.Size(), err
+}
@@ -15,12 +15,43 @@ const (
 )
 
 func init() {
-	nameMap.Store(uint16(TypeNone), "No Compress")
-	nameMap.Store(uint16(TypeGzip), "gzip")
-	nameMap.Store(uint16(TypeZlib), "zlib")
+	Register(TypeNone, "No Compress", func() Compressor {
+		return NewBufferCompressor(DefaultBufferSize)
+	})
+	Register(TypeGzip, "gzip", func() Compressor {
+		return NewGzipCompressor()
+	})
+	Register(TypeZlib, "zlib", func() Compressor {
+		return NewZlibCompressor()
+	})
+	Register(TypeZstd, "zstd", func() Compressor {
+		return NewZstdCompressor()
+	})
+	Register(TypeLz4, "lz4", func() Compressor {
+		return NewLz4Compressor()
+	})
+	Register(TypeSnappy, "snappy", func() Compressor {
+		return NewSnappyCompressor()
+	})
+	Register(TypeFlate, "flate", func() Compressor {
+		return NewFlateCompressor()
+	})
+	Register(TypeLzw, "lzw", func() Compressor {
+		return NewLzwCompressor()
+	})
+	Register(TypeBzip2, "bzip2", func() Compressor {
+		return NewBzip2Compressor()
+	})
+	Register(TypeAdaptive, "adaptive", func() Compressor {
+		return NewAdaptiveCompressor(NewGzipCompressor())
+	})
 }
 
+// 创建压缩器，读取时根据文件头记录的压缩类型创建
+type Factory func() Compressor
+
 var nameMap = sync.Map{}
+var factoryMap = sync.Map{}
 
 func GetName(compressType uint16) string {
 	if v, ok := nameMap.Load(compressType); ok {
@@ -34,3 +65,38 @@ func RegisterType(compressType uint16, compressName string) bool {
 	_, loaded := nameMap.LoadOrStore(compressType, compressName)
 	return !loaded
 }
+
+// 注册压缩类型的名称及工厂，注册后该类型的文件可读写。类型已注册工厂时返回false
+func Register(compressType uint16, compressName string, factory Factory) bool {
+	if factory == nil {
+		return false
+	}
+	if _, loaded := factoryMap.LoadOrStore(compressType, factory); loaded {
+		return false
+	}
+	nameMap.Store(compressType, compressName)
+	return true
+}
+
+// 根据压缩类型创建压缩器，类型未注册时返回false
+func NewCompressor(compressType uint16) (Compressor, bool) {
+	if v, ok := factoryMap.Load(compressType); ok {
+		return v.(Factory)(), true
+	}
+	return nil, false
+}
+
+// 根据名称获得已注册工厂的压缩类型
+func GetType(compressName string) (uint16, bool) {
+	var ret uint16
+	found := false
+	factoryMap.Range(func(key, value interface{}) bool {
+		if v, ok := nameMap.Load(key); ok && v.(string) == compressName {
+			ret = key.(uint16)
+			found = true
+			return false
+		}
+		return true
+	})
+	return ret, found
+}
@@ -14,7 +14,7 @@ const(
 )
 
 type bufferCompressor struct {
-	buf []byte
+	buf *bufferPool
 }
 
 func NewBufferCompressor(size int) *bufferCompressor {
@@ -22,7 +22,7 @@ func NewBufferCompressor(size int) *bufferCompressor {
 		size = DefaultBufferSize
 	}
 	return &bufferCompressor{
-		buf: make([]byte, size),
+		buf: newBufferPool(size),
 	}
 }
 
@@ -38,7 +38,9 @@ func (c *bufferCompressor) Type() Type {
 // 返回after：压缩后数据大小
 // 返回err：发生错误时返回，无错误返回nil
 func (c *bufferCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
-	n, err := io.CopyBuffer(dstWriter, srcReader, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	n, err := io.CopyBuffer(dstWriter, srcReader, buf)
 	return n, n, err
 }
 
@@ -49,6 +51,8 @@ func (c *bufferCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (b
 // 返回after：解压后数据大小
 // 返回err：发生错误时返回，无错误返回nil
 func (c *bufferCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
-	n, err := io.CopyBuffer(dstWriter, srcReader, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	n, err := io.CopyBuffer(dstWriter, srcReader, buf)
 	return n, n, err
 }
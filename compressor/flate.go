@@ -0,0 +1,131 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"compress/flate"
+	"io"
+)
+
+const (
+	TypeFlate = 6
+)
+
+// 原始DEFLATE格式，无gzip、zlib的头部及校验，适合大量小数据
+type flateCompressor struct {
+	level GzipCompressLevel
+	dict  []byte
+	buf   *bufferPool
+}
+
+type FlateOpt func(c *flateCompressor)
+
+func NewFlateCompressor(opts ...FlateOpt) *flateCompressor {
+	ret := &flateCompressor{
+		level: DefaultCompression,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	return ret
+}
+
+// 压缩类型
+func (c *flateCompressor) Type() Type {
+	return TypeFlate
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *flateCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	w := NewSizeWriter(dstWriter)
+	z, err := flate.NewWriterDict(w, int(c.level), c.dict)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		after = w.Size()
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, srcReader, buf)
+	return
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *flateCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	z := flate.NewReaderDict(r, c.dict)
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		before = r.Size()
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z, buf)
+	return
+}
+
+// 获得字典，未使用字典时返回nil
+func (c *flateCompressor) Dict() []byte {
+	return c.dict
+}
+
+// 使用预置字典创建新的压缩器，字典为任意数据，通常为与待压缩数据相似的样本
+func (c *flateCompressor) WithDict(dict []byte) (Compressor, error) {
+	ret := *c
+	ret.dict = dict
+	return &ret, nil
+}
+
+type flateOpts struct{}
+
+var FlateOpts flateOpts
+
+func (opt flateOpts) WithBuffer(buf []byte) FlateOpt {
+	return func(c *flateCompressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt flateOpts) BufferSize(size int) FlateOpt {
+	return func(c *flateCompressor) {
+		c.buf = newBufferPool(size)
+	}
+}
+
+// 压缩等级与gzip相同
+func (opt flateOpts) Level(level GzipCompressLevel) FlateOpt {
+	return func(c *flateCompressor) {
+		c.level = level
+	}
+}
+
+// 使用预置字典，字典保存于jenga文件中，读取时自动加载
+func (opt flateOpts) Dict(dict []byte) FlateOpt {
+	return func(c *flateCompressor) {
+		c.dict = dict
+	}
+}
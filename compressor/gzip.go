@@ -6,8 +6,10 @@
 package compressor
 
 import (
+	"bufio"
 	"compress/gzip"
 	"io"
+	"sync"
 )
 
 const (
@@ -24,7 +26,16 @@ type GzipCompressLevel int
 
 type gzipCompressor struct {
 	level GzipCompressLevel
-	buf   []byte
+	buf   *bufferPool
+	// 复用的gzip.Writer及gzipReader
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// 复用的解压状态，bufio.Reader避免每次Reset时重新分配
+type gzipReader struct {
+	br *bufio.Reader
+	z  *gzip.Reader
 }
 
 type GzipOpt func(c *gzipCompressor)
@@ -37,7 +48,7 @@ func NewGzipCompressor(opts ...GzipOpt) *gzipCompressor {
 		opt(ret)
 	}
 	if ret.buf == nil {
-		ret.buf = make([]byte, DefaultBufferSize)
+		ret.buf = newBufferPool(DefaultBufferSize)
 	}
 	return ret
 }
@@ -55,7 +66,7 @@ func (c *gzipCompressor) Type() Type {
 // 返回err：发生错误时返回，无错误返回nil
 func (c *gzipCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
 	w := NewSizeWriter(dstWriter)
-	z, err := gzip.NewWriterLevel(w, int(c.level))
+	z, err := c.getWriter(w)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -65,8 +76,11 @@ func (c *gzipCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (bef
 			err = e
 		}
 		after = w.Size()
+		c.writers.Put(z)
 	}()
-	before, err = io.CopyBuffer(z, srcReader, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, srcReader, buf)
 	return
 }
 
@@ -78,34 +92,66 @@ func (c *gzipCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (bef
 // 返回err：发生错误时返回，无错误返回nil
 func (c *gzipCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
 	r := NewSizeReader(srcReader)
-	z, err := gzip.NewReader(r)
+	z, err := c.getReader(r)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer func() {
-		e := z.Close()
+		e := z.z.Close()
 		if e != nil {
 			err = e
 		}
 		before = r.Size()
+		c.readers.Put(z)
 	}()
-	after, err = io.CopyBuffer(dstWriter, z, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z.z, buf)
 	return
 }
 
+func (c *gzipCompressor) getWriter(w io.Writer) (*gzip.Writer, error) {
+	if z, ok := c.writers.Get().(*gzip.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	return gzip.NewWriterLevel(w, int(c.level))
+}
+
+func (c *gzipCompressor) getReader(r io.Reader) (*gzipReader, error) {
+	z, ok := c.readers.Get().(*gzipReader)
+	if ok {
+		z.br.Reset(r)
+	} else {
+		z = &gzipReader{br: bufio.NewReader(r)}
+	}
+	var err error
+	if z.z == nil {
+		z.z, err = gzip.NewReader(z.br)
+	} else {
+		err = z.z.Reset(z.br)
+	}
+	if err != nil {
+		z.z = nil
+		c.readers.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
 type gzipOpts struct{}
 
 var GzipOpts gzipOpts
 
 func (opt gzipOpts) WithBuffer(buf []byte) GzipOpt {
 	return func(c *gzipCompressor) {
-		c.buf = buf
+		c.buf = newBufferPoolWith(buf)
 	}
 }
 
 func (opt gzipOpts) BufferSize(size int) GzipOpt {
 	return func(c *gzipCompressor) {
-		c.buf = make([]byte, size)
+		c.buf = newBufferPool(size)
 	}
 }
 
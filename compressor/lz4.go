@@ -0,0 +1,140 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"github.com/pierrec/lz4/v4"
+	"io"
+	"sync"
+)
+
+const (
+	TypeLz4 = 4
+
+	Lz4Fast   Lz4CompressLevel = Lz4CompressLevel(lz4.Fast)
+	Lz4Level1 Lz4CompressLevel = Lz4CompressLevel(lz4.Level1)
+	Lz4Level5 Lz4CompressLevel = Lz4CompressLevel(lz4.Level5)
+	Lz4Level9 Lz4CompressLevel = Lz4CompressLevel(lz4.Level9)
+)
+
+type Lz4CompressLevel uint32
+
+type lz4Compressor struct {
+	level Lz4CompressLevel
+	buf   *bufferPool
+	// 复用的lz4.Writer及lz4.Reader
+	writers sync.Pool
+	readers sync.Pool
+}
+
+type Lz4Opt func(c *lz4Compressor)
+
+func NewLz4Compressor(opts ...Lz4Opt) *lz4Compressor {
+	ret := &lz4Compressor{
+		level: Lz4Fast,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	return ret
+}
+
+// 压缩类型
+func (c *lz4Compressor) Type() Type {
+	return TypeLz4
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *lz4Compressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	w := NewSizeWriter(dstWriter)
+	z, err := c.getWriter(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		after = w.Size()
+		c.writers.Put(z)
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, srcReader, buf)
+	return
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *lz4Compressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	z := c.getReader(r)
+	defer func() {
+		before = r.Size()
+		c.readers.Put(z)
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z, buf)
+	return
+}
+
+// 新建的Writer使用64KB的块，减少压缩、解压时分配的缓存
+func (c *lz4Compressor) getWriter(w io.Writer) (*lz4.Writer, error) {
+	if z, ok := c.writers.Get().(*lz4.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	z := lz4.NewWriter(w)
+	err := z.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(c.level)), lz4.BlockSizeOption(lz4.Block64Kb))
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (c *lz4Compressor) getReader(r io.Reader) *lz4.Reader {
+	if z, ok := c.readers.Get().(*lz4.Reader); ok {
+		z.Reset(r)
+		return z
+	}
+	return lz4.NewReader(r)
+}
+
+type lz4Opts struct{}
+
+var Lz4Opts lz4Opts
+
+func (opt lz4Opts) WithBuffer(buf []byte) Lz4Opt {
+	return func(c *lz4Compressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt lz4Opts) BufferSize(size int) Lz4Opt {
+	return func(c *lz4Compressor) {
+		c.buf = newBufferPool(size)
+	}
+}
+
+func (opt lz4Opts) Level(level Lz4CompressLevel) Lz4Opt {
+	return func(c *lz4Compressor) {
+		c.level = level
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"compress/lzw"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	TypeLzw = 7
+
+	// GIF、PDF使用的位顺序
+	LzwLSB = LzwOrder(lzw.LSB)
+	// TIFF使用的位顺序
+	LzwMSB = LzwOrder(lzw.MSB)
+
+	lzwLitWidth = 8
+)
+
+type LzwOrder int
+
+type lzwCompressor struct {
+	order LzwOrder
+	buf   *bufferPool
+}
+
+type LzwOpt func(c *lzwCompressor)
+
+func NewLzwCompressor(opts ...LzwOpt) *lzwCompressor {
+	ret := &lzwCompressor{
+		order: LzwLSB,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	return ret
+}
+
+// 压缩类型
+func (c *lzwCompressor) Type() Type {
+	return TypeLzw
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *lzwCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	w := NewSizeWriter(dstWriter)
+	z := lzw.NewWriter(w, lzw.Order(c.order), lzwLitWidth)
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		after = w.Size()
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, srcReader, buf)
+	return
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *lzwCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	z := lzw.NewReader(r, lzw.Order(c.order), lzwLitWidth)
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		before = r.Size()
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z, buf)
+	if err == nil {
+		// 读取到结束码后停止，读取剩余的填充数据
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+	return
+}
+
+type lzwOpts struct{}
+
+var LzwOpts lzwOpts
+
+func (opt lzwOpts) WithBuffer(buf []byte) LzwOpt {
+	return func(c *lzwCompressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt lzwOpts) BufferSize(size int) LzwOpt {
+	return func(c *lzwCompressor) {
+		c.buf = newBufferPool(size)
+	}
+}
+
+// 位顺序，默认为LzwLSB。读写需使用相同的位顺序
+func (opt lzwOpts) Order(order LzwOrder) LzwOpt {
+	return func(c *lzwCompressor) {
+		c.order = order
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"github.com/golang/snappy"
+	"io"
+	"sync"
+)
+
+const (
+	TypeSnappy = 5
+)
+
+// 使用snappy framing格式
+type snappyCompressor struct {
+	buf *bufferPool
+	// 复用的snappy.Writer及snappy.Reader
+	writers sync.Pool
+	readers sync.Pool
+}
+
+type SnappyOpt func(c *snappyCompressor)
+
+func NewSnappyCompressor(opts ...SnappyOpt) *snappyCompressor {
+	ret := &snappyCompressor{}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	return ret
+}
+
+// 压缩类型
+func (c *snappyCompressor) Type() Type {
+	return TypeSnappy
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *snappyCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	w := NewSizeWriter(dstWriter)
+	z := c.getWriter(w)
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		after = w.Size()
+		c.writers.Put(z)
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, srcReader, buf)
+	return
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *snappyCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	z := c.getReader(r)
+	defer func() {
+		before = r.Size()
+		c.readers.Put(z)
+	}()
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(dstWriter, z, buf)
+	return
+}
+
+func (c *snappyCompressor) getWriter(w io.Writer) *snappy.Writer {
+	if z, ok := c.writers.Get().(*snappy.Writer); ok {
+		z.Reset(w)
+		return z
+	}
+	return snappy.NewBufferedWriter(w)
+}
+
+func (c *snappyCompressor) getReader(r io.Reader) *snappy.Reader {
+	if z, ok := c.readers.Get().(*snappy.Reader); ok {
+		z.Reset(r)
+		return z
+	}
+	return snappy.NewReader(r)
+}
+
+type snappyOpts struct{}
+
+var SnappyOpts snappyOpts
+
+func (opt snappyOpts) WithBuffer(buf []byte) SnappyOpt {
+	return func(c *snappyCompressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt snappyOpts) BufferSize(size int) SnappyOpt {
+	return func(c *snappyCompressor) {
+		c.buf = newBufferPool(size)
+	}
+}
@@ -6,8 +6,10 @@
 package compressor
 
 import (
+	"bufio"
 	"compress/zlib"
 	"io"
+	"sync"
 )
 
 const (
@@ -15,20 +17,32 @@ const (
 )
 
 type zlibCompressor struct {
-	buf []byte
+	level GzipCompressLevel
+	buf   *bufferPool
+	// 复用的zlib.Writer及zlibReader
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// 复用的解压状态，bufio.Reader避免每次Reset时重新分配
+type zlibReader struct {
+	br *bufio.Reader
+	z  io.ReadCloser
 }
 
 type ZlibOpt func(c *zlibCompressor)
 
 func NewZlibCompressor(opts ...ZlibOpt) *zlibCompressor {
-	ret := &zlibCompressor{}
+	ret := &zlibCompressor{
+		level: DefaultCompression,
+	}
 
 	for _, opt := range opts {
 		opt(ret)
 	}
 
 	if ret.buf == nil {
-		ret.buf = make([]byte, DefaultBufferSize)
+		ret.buf = newBufferPool(DefaultBufferSize)
 	}
 
 	return ret
@@ -47,7 +61,10 @@ func (c *zlibCompressor) Type() Type {
 // 返回err：发生错误时返回，无错误返回nil
 func (c *zlibCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
 	w := NewSizeWriter(dstWriter)
-	z := zlib.NewWriter(w)
+	z, err := c.getWriter(w)
+	if err != nil {
+		return 0, 0, err
+	}
 	r := NewSizeReader(srcReader)
 	defer func() {
 		e := z.Close()
@@ -55,8 +72,11 @@ func (c *zlibCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (bef
 			err = e
 		}
 		after = w.Size()
+		c.writers.Put(z)
 	}()
-	before, err = io.CopyBuffer(z, r, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	before, err = io.CopyBuffer(z, r, buf)
 	return
 }
 
@@ -68,34 +88,73 @@ func (c *zlibCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (bef
 // 返回err：发生错误时返回，无错误返回nil
 func (c *zlibCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
 	r := NewSizeReader(srcReader)
-	z, err := zlib.NewReader(r)
+	z, err := c.getReader(r)
 	if err != nil {
 		return 0, 0, err
 	}
 	w := NewSizeWriter(dstWriter)
 	defer func() {
-		e := z.Close()
+		e := z.z.Close()
 		if e != nil {
 			err = e
 		}
 		before = r.Size()
+		c.readers.Put(z)
 	}()
-	after, err = io.CopyBuffer(w, z, c.buf)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	after, err = io.CopyBuffer(w, z.z, buf)
 	return
 }
 
+func (c *zlibCompressor) getWriter(w io.Writer) (*zlib.Writer, error) {
+	if z, ok := c.writers.Get().(*zlib.Writer); ok {
+		z.Reset(w)
+		return z, nil
+	}
+	return zlib.NewWriterLevel(w, int(c.level))
+}
+
+func (c *zlibCompressor) getReader(r io.Reader) (*zlibReader, error) {
+	z, ok := c.readers.Get().(*zlibReader)
+	if ok {
+		z.br.Reset(r)
+	} else {
+		z = &zlibReader{br: bufio.NewReader(r)}
+	}
+	var err error
+	if z.z == nil {
+		z.z, err = zlib.NewReader(z.br)
+	} else {
+		err = z.z.(zlib.Resetter).Reset(z.br, nil)
+	}
+	if err != nil {
+		z.z = nil
+		c.readers.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
 type zlibOpts struct{}
 
 var ZlibOpts zlibOpts
 
 func (opt zlibOpts) WithBuffer(buf []byte) ZlibOpt {
 	return func(c *zlibCompressor) {
-		c.buf = buf
+		c.buf = newBufferPoolWith(buf)
 	}
 }
 
 func (opt zlibOpts) BufferSize(size int) ZlibOpt {
 	return func(c *zlibCompressor) {
-		c.buf = make([]byte, size)
+		c.buf = newBufferPool(size)
+	}
+}
+
+// 压缩等级与gzip相同
+func (opt zlibOpts) Level(level GzipCompressLevel) ZlibOpt {
+	return func(c *zlibCompressor) {
+		c.level = level
 	}
 }
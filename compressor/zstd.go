@@ -0,0 +1,285 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package compressor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+)
+
+const (
+	TypeZstd = 3
+
+	ZstdSpeedFastest           ZstdCompressLevel = ZstdCompressLevel(zstd.SpeedFastest)
+	ZstdSpeedDefault           ZstdCompressLevel = ZstdCompressLevel(zstd.SpeedDefault)
+	ZstdSpeedBetterCompression ZstdCompressLevel = ZstdCompressLevel(zstd.SpeedBetterCompression)
+	ZstdSpeedBestCompression   ZstdCompressLevel = ZstdCompressLevel(zstd.SpeedBestCompression)
+)
+
+type ZstdCompressLevel int
+
+// 将zstd标准压缩等级（1-22）转换为ZstdCompressLevel
+func ZstdLevel(level int) ZstdCompressLevel {
+	return ZstdCompressLevel(zstd.EncoderLevelFromZstd(level))
+}
+
+// 数据不超过该大小时使用EncodeAll、DecodeAll一次压缩、解压
+const zstdDecodeAllSize = 1 << 20
+
+// 解压小数据使用的Decoder，按字典缓存。
+// Decoder包含常驻的goroutine，无法在压缩器释放时关闭，因此不使用sync.Pool而是全局共用（DecodeAll可并发调用）
+var zstdDecoders sync.Map
+
+// 一次压缩、解压时保存全部数据的缓存，不超过zstdDecodeAllSize
+var zstdBuffers = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+type zstdCompressor struct {
+	level ZstdCompressLevel
+	dict  []byte
+	// 字典的摘要，获取共用的Decoder
+	dictSum [sha256.Size]byte
+	buf     *bufferPool
+	// 复用的zstd.Encoder，Close后可通过Reset重新使用
+	writers sync.Pool
+}
+
+type ZstdOpt func(c *zstdCompressor)
+
+func NewZstdCompressor(opts ...ZstdOpt) *zstdCompressor {
+	ret := &zstdCompressor{
+		level: ZstdSpeedDefault,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.buf == nil {
+		ret.buf = newBufferPool(DefaultBufferSize)
+	}
+	ret.dictSum = sha256.Sum256(ret.dict)
+	return ret
+}
+
+// 压缩类型
+func (c *zstdCompressor) Type() Type {
+	return TypeZstd
+}
+
+// 将srcReader的数据压缩至dstWriter
+// 参数dstWriter：压缩数据写入的writer
+// 参数srcReader：原始数据读取的reader
+// 返回before：原始数据大小
+// 返回after：压缩后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *zstdCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	z, err := c.getWriter()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer c.writers.Put(z)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	b := zstdBuffers.Get().(*bytes.Buffer)
+	defer zstdBuffers.Put(b)
+	data, all, err := readAll(srcReader, buf, b, zstdDecodeAllSize)
+	if err != nil {
+		return int64(len(data)), 0, err
+	}
+	if all {
+		// 一次压缩，压缩数据包含原始数据大小，解压时可使用DecodeAll
+		out := c.buf.get()
+		defer c.buf.put(out)
+		wn, err := dstWriter.Write(z.EncodeAll(data, out[:0]))
+		return int64(len(data)), int64(wn), err
+	}
+	w := NewSizeWriter(dstWriter)
+	z.Reset(w)
+	defer func() {
+		e := z.Close()
+		if e != nil && err == nil {
+			err = e
+		}
+		after = w.Size()
+	}()
+	_, err = z.Write(data)
+	if err != nil {
+		return int64(len(data)), 0, err
+	}
+	before, err = io.CopyBuffer(z, srcReader, buf)
+	before += int64(len(data))
+	return
+}
+
+// 将srcReader的数据解压至dstWriter
+// 参数dstWriter：解压数据写入的writer
+// 参数srcReader：压缩数据读取的reader
+// 返回before：压缩数据大小
+// 返回after：解压后数据大小
+// 返回err：发生错误时返回，无错误返回nil
+func (c *zstdCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (before int64, after int64, err error) {
+	r := NewSizeReader(srcReader)
+	buf := c.buf.get()
+	defer c.buf.put(buf)
+	b := zstdBuffers.Get().(*bytes.Buffer)
+	defer zstdBuffers.Put(b)
+	data, all, err := readAll(r, buf, b, zstdDecodeAllSize)
+	if err != nil {
+		return r.Size(), 0, err
+	}
+	if all && c.decodeAll(data) {
+		d, err := c.getDecoder()
+		if err != nil {
+			return r.Size(), 0, err
+		}
+		out := c.buf.get()
+		defer c.buf.put(out)
+		ret, err := d.DecodeAll(data, out[:0])
+		if err == nil {
+			wn, err := dstWriter.Write(ret)
+			return r.Size(), int64(wn), err
+		}
+		// 包含多个frame时可能超出DecodeAll的大小限制
+		if err != zstd.ErrDecoderSizeExceeded && err != zstd.ErrWindowSizeExceeded {
+			return r.Size(), 0, err
+		}
+	}
+
+	opts := []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+	}
+	if len(c.dict) > 0 {
+		opts = append(opts, zstd.WithDecoderDicts(c.dict))
+	}
+	z, err := zstd.NewReader(io.MultiReader(bytes.NewReader(data), r), opts...)
+	if err != nil {
+		return r.Size(), 0, err
+	}
+	defer func() {
+		z.Close()
+		before = r.Size()
+	}()
+	cb := c.buf.get()
+	defer c.buf.put(cb)
+	after, err = io.CopyBuffer(dstWriter, z, cb)
+	return
+}
+
+// 读取r的数据直至读取完毕或超出limit，读取完毕时返回true。数据小于buf时使用buf保存，否则使用b保存
+func readAll(r io.Reader, buf []byte, b *bytes.Buffer, limit int64) ([]byte, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return buf[:n], true, nil
+	}
+	if err != nil {
+		return buf[:n], false, err
+	}
+	b.Reset()
+	b.Write(buf)
+	_, err = b.ReadFrom(io.LimitReader(r, limit-int64(n)+1))
+	return b.Bytes(), err == nil && int64(b.Len()) <= limit, err
+}
+
+// 压缩数据为单个包含原始数据大小的frame且原始数据不超过zstdDecodeAllSize时使用DecodeAll解压
+func (c *zstdCompressor) decodeAll(data []byte) bool {
+	h := zstd.Header{}
+	err := h.Decode(data)
+	return err == nil && h.HasFCS && h.FrameContentSize <= zstdDecodeAllSize
+}
+
+func (c *zstdCompressor) getWriter() (*zstd.Encoder, error) {
+	if z, ok := c.writers.Get().(*zstd.Encoder); ok {
+		return z, nil
+	}
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevel(c.level)),
+		zstd.WithEncoderConcurrency(1),
+		// 空数据同样写入frame
+		zstd.WithZeroFrames(true),
+	}
+	if len(c.dict) > 0 {
+		opts = append(opts, zstd.WithEncoderDict(c.dict))
+	}
+	return zstd.NewWriter(nil, opts...)
+}
+
+func (c *zstdCompressor) getDecoder() (*zstd.Decoder, error) {
+	if d, ok := zstdDecoders.Load(c.dictSum); ok {
+		return d.(*zstd.Decoder), nil
+	}
+	opts := []zstd.DOption{
+		zstd.WithDecoderMaxMemory(zstdDecodeAllSize),
+	}
+	if len(c.dict) > 0 {
+		opts = append(opts, zstd.WithDecoderDicts(c.dict))
+	}
+	d, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if v, loaded := zstdDecoders.LoadOrStore(c.dictSum, d); loaded {
+		d.Close()
+		return v.(*zstd.Decoder), nil
+	}
+	return d, nil
+}
+
+// 获得字典，未使用字典时返回nil
+func (c *zstdCompressor) Dict() []byte {
+	return c.dict
+}
+
+// 使用字典创建新的压缩器，字典需为zstd格式（如zstd --train生成）
+func (c *zstdCompressor) WithDict(dict []byte) (Compressor, error) {
+	if len(dict) > 0 {
+		// 校验字典格式
+		z, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(dict))
+		if err != nil {
+			return nil, err
+		}
+		z.Close()
+	}
+	return &zstdCompressor{
+		level:   c.level,
+		dict:    dict,
+		dictSum: sha256.Sum256(dict),
+		buf:     c.buf,
+	}, nil
+}
+
+type zstdOpts struct{}
+
+var ZstdOpts zstdOpts
+
+func (opt zstdOpts) WithBuffer(buf []byte) ZstdOpt {
+	return func(c *zstdCompressor) {
+		c.buf = newBufferPoolWith(buf)
+	}
+}
+
+func (opt zstdOpts) BufferSize(size int) ZstdOpt {
+	return func(c *zstdCompressor) {
+		c.buf = newBufferPool(size)
+	}
+}
+
+func (opt zstdOpts) Level(level ZstdCompressLevel) ZstdOpt {
+	return func(c *zstdCompressor) {
+		c.level = level
+	}
+}
+
+// 使用zstd格式的字典（如zstd --train生成），字典保存于jenga文件中，读取时自动加载
+func (opt zstdOpts) Dict(dict []byte) ZstdOpt {
+	return func(c *zstdCompressor) {
+		c.dict = dict
+	}
+}
@@ -13,6 +13,8 @@ const (
 	OpFlagWriteOnly OpenFlag = 1 << 1
 	// 如不存在则创建
 	OpFlagCreate    OpenFlag = 1 << 2
+	// 读写
+	OpFlagReadWrite = OpFlagReadOnly | OpFlagWriteOnly
 )
 
 func (f OpenFlag) CanRead() bool {
@@ -0,0 +1,374 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// jenga文件的fs.FS适配，以"/"分隔的key视为目录树
+type jengaFS struct {
+	j     Jenga
+	files map[string]string
+	dirs  map[string]map[string]bool
+}
+
+// 将jenga适配为fs.FS（同时实现fs.ReadDirFS及fs.StatFS）
+// j需以OpFlagReadOnly打开，创建时读取j的key列表
+func FS(j Jenga) fs.FS {
+	ret := &jengaFS{
+		j:     j,
+		files: map[string]string{},
+		dirs: map[string]map[string]bool{
+			".": {},
+		},
+	}
+	for _, key := range j.KeyList() {
+		name := path.Clean("/" + filepath.ToSlash(key))[1:]
+		if name == "" {
+			continue
+		}
+		ret.files[name] = key
+		for name != "." {
+			dir := path.Dir(name)
+			children, ok := ret.dirs[dir]
+			if !ok {
+				children = map[string]bool{}
+				ret.dirs[dir] = children
+			}
+			children[path.Base(name)] = true
+			name = dir
+		}
+	}
+	return ret
+}
+
+func (f *jengaFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if key, ok := f.files[name]; ok {
+		r, err := f.j.OpenReader(key)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if sr, ok := r.(sectionReader); ok {
+			return &jengaSectionFile{
+				sectionReader: sr,
+				info:          f.fileInfo(name, key, sr.Size()),
+			}, nil
+		}
+		return &jengaFile{
+			fs:   f,
+			name: name,
+			key:  key,
+			r:    r,
+			size: -1,
+		}, nil
+	}
+	if _, ok := f.dirs[name]; ok {
+		entries, _ := f.ReadDir(name)
+		return &jengaDir{
+			info:    newDirInfo(name),
+			entries: entries,
+		}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *jengaFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if key, ok := f.files[name]; ok {
+		size, err := f.size(key)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		return f.fileInfo(name, key, size), nil
+	}
+	if _, ok := f.dirs[name]; ok {
+		return newDirInfo(name), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *jengaFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	children, ok := f.dirs[name]
+	if !ok {
+		if _, ok := f.files[name]; ok {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	ret := make([]fs.DirEntry, 0, len(children))
+	for child := range children {
+		childPath := path.Join(name, child)
+		_, isDir := f.dirs[childPath]
+		if _, isFile := f.files[childPath]; isFile {
+			isDir = false
+		}
+		ret = append(ret, &dirEntry{
+			fs:    f,
+			path:  childPath,
+			isDir: isDir,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name() < ret[j].Name()
+	})
+	return ret, nil
+}
+
+// 获得key关联数据解压后的大小
+func (f *jengaFS) size(key string) (int64, error) {
+	if info, err := f.j.Stat(key); err == nil && info.OriginSize >= 0 {
+		return info.OriginSize, nil
+	}
+	r, err := f.j.OpenReader(key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if sr, ok := r.(sectionReader); ok {
+		return sr.Size(), nil
+	}
+	return io.Copy(ioutil.Discard, r)
+}
+
+type sectionReader interface {
+	io.ReadSeeker
+	io.ReaderAt
+	Size() int64
+}
+
+// 未压缩的数据，支持随机读取
+type jengaSectionFile struct {
+	sectionReader
+	info *fileInfo
+}
+
+func (f *jengaSectionFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *jengaSectionFile) Close() error {
+	return nil
+}
+
+// 压缩的数据，Seek时按需重新打开并跳过数据
+type jengaFile struct {
+	fs   *jengaFS
+	name string
+	key  string
+	r    io.ReadCloser
+	// 已读取的位置
+	off int64
+	// Seek设置的位置
+	pos  int64
+	size int64
+}
+
+func (f *jengaFile) Stat() (fs.FileInfo, error) {
+	size, err := f.getSize()
+	if err != nil {
+		return nil, err
+	}
+	return f.fs.fileInfo(f.name, f.key, size), nil
+}
+
+func (f *jengaFile) Read(p []byte) (int, error) {
+	if f.pos != f.off {
+		err := f.seekStream()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.r.Read(p)
+	f.off += int64(n)
+	f.pos = f.off
+	return n, err
+}
+
+func (f *jengaFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.pos + offset
+	case io.SeekEnd:
+		size, err := f.getSize()
+		if err != nil {
+			return 0, err
+		}
+		pos = size + offset
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if pos < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *jengaFile) seekStream() error {
+	if f.pos < f.off {
+		_ = f.r.Close()
+		r, err := f.fs.j.OpenReader(f.key)
+		if err != nil {
+			return err
+		}
+		f.r = r
+		f.off = 0
+	}
+	n, err := io.CopyN(ioutil.Discard, f.r, f.pos-f.off)
+	f.off += n
+	if err == io.EOF {
+		f.off = f.pos
+		return nil
+	}
+	return err
+}
+
+func (f *jengaFile) getSize() (int64, error) {
+	if f.size < 0 {
+		size, err := f.fs.size(f.key)
+		if err != nil {
+			return 0, err
+		}
+		f.size = size
+	}
+	return f.size, nil
+}
+
+func (f *jengaFile) Close() error {
+	return f.r.Close()
+}
+
+type jengaDir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *jengaDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *jengaDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *jengaDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return left, nil
+	}
+	if len(left) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(left) {
+		n = len(left)
+	}
+	d.off += n
+	return left[:n], nil
+}
+
+func (d *jengaDir) Close() error {
+	return nil
+}
+
+type dirEntry struct {
+	fs    *jengaFS
+	path  string
+	isDir bool
+}
+
+func (e *dirEntry) Name() string {
+	return path.Base(e.path)
+}
+
+func (e *dirEntry) IsDir() bool {
+	return e.isDir
+}
+
+func (e *dirEntry) Type() fs.FileMode {
+	if e.isDir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	return e.fs.Stat(e.path)
+}
+
+// 包含元数据时使用记录的权限及修改时间
+func (f *jengaFS) fileInfo(name, key string, size int64) *fileInfo {
+	ret := &fileInfo{
+		name: path.Base(name),
+		size: size,
+		mode: 0444,
+	}
+	if info, err := f.j.Stat(key); err == nil {
+		if info.Mode != 0 {
+			ret.mode = info.Mode.Perm()
+		}
+		ret.modTime = info.ModTime
+	}
+	return ret
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newDirInfo(name string) *fileInfo {
+	return &fileInfo{
+		name: path.Base(name),
+		mode: fs.ModeDir | 0555,
+	}
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	return i.mode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.mode.IsDir()
+}
+
+func (i *fileInfo) Sys() interface{} {
+	return nil
+}
@@ -6,6 +6,7 @@
 package jenga
 
 import (
+	"github.com/xfali/jenga/blk"
 	"github.com/xfali/jenga/flags"
 	"io"
 )
@@ -17,13 +18,21 @@ const (
 	OpFlagWriteOnly = flags.OpFlagWriteOnly
 	// 如不存在则创建
 	OpFlagCreate = flags.OpFlagCreate
+	// 读写（仅V2、V3格式支持）
+	OpFlagReadWrite = flags.OpFlagReadWrite
 )
 
 type OpenFlag = flags.OpenFlag
 
+// 实体元数据：权限、修改时间、所有者及自定义属性
+type EntryMeta = jengablk.EntryMeta
+
+// 实体信息
+type EntryInfo = jengablk.EntryInfo
+
 type Jenga interface {
 	// 打开
-	// flag：打开标志，一般而言不能同时包含OpFlagReadOnly和OpFlagWriteOnly
+	// flag：打开标志，V2、V3格式支持OpFlagReadWrite，写入的数据可立即读取，其他格式不能同时包含OpFlagReadOnly和OpFlagWriteOnly
 	Open(flag OpenFlag) error
 
 	// 关闭
@@ -46,6 +55,19 @@ type Writer interface {
 	// return size: 写入数据的长度
 	// return err: 当出错时返回
 	Write(key string, r io.Reader) (size int64, err error)
+
+	// 使用key保存数据及其元数据
+	// param key: 数据关联的key
+	// param meta: 数据的元数据
+	// param r: 写入数据的reader
+	// return size: 写入数据的长度
+	// return err: 当出错时返回
+	WriteWithMeta(key string, meta EntryMeta, r io.Reader) (size int64, err error)
+
+	// 删除key关联的数据
+	// param key: 数据关联的key
+	// return err: 当出错时返回
+	Delete(key string) error
 }
 
 type Reader interface {
@@ -55,4 +77,27 @@ type Reader interface {
 	// return size: 读取数据的长度
 	// return err: 当出错时返回
 	Read(key string, w io.Writer) (size int64, err error)
+
+	// 获得key关联数据的reader，数据在读取时解压
+	// param key: 数据关联的key
+	// return reader: 读取数据的reader，使用完毕后需Close
+	// return err: 当出错时返回
+	OpenReader(key string) (reader io.ReadCloser, err error)
+
+	// 获得key关联数据的io.SectionReader，仅支持未压缩的数据
+	// param key: 数据关联的key
+	// return reader: 支持随机读取的reader
+	// return err: 当出错时返回
+	OpenSection(key string) (reader *io.SectionReader, err error)
+
+	// 获得key关联数据的信息（大小、偏移、压缩类型及元数据），不读取数据
+	// param key: 数据关联的key
+	// return info: 数据的信息
+	// return err: 当出错时返回
+	Stat(key string) (info EntryInfo, err error)
+
+	// key关联的数据是否存在，不读取数据
+	// param key: 数据关联的key
+	// return bool: 存在返回true
+	Exists(key string) bool
 }
@@ -16,11 +16,17 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/jengaerr"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var addViper = viper.New()
@@ -35,29 +41,93 @@ var addCmd = &cobra.Command{
 		source := addViper.GetString(ParamSourceFile)
 		gzip := addViper.GetBool(ParamJengaGzip)
 		zlib := addViper.GetBool(ParamJengaZlib)
+		zstd := addViper.GetBool(ParamJengaZstd)
+		lz4 := addViper.GetBool(ParamJengaLz4)
+		snappy := addViper.GetBool(ParamJengaSnappy)
+		compressName := addViper.GetString(ParamJengaCompress)
+		checksum := addViper.GetBool(ParamJengaChecksum)
+		noCompressExt := addViper.GetStringSlice(ParamNoCompressExt)
+		cipherName := addViper.GetString(ParamCipher)
+		encryptKey := addViper.GetBool(ParamEncryptKey)
+		sec := readSecret(addViper)
+		attrs, err := parseAttrs(addViper.GetStringSlice(ParamAttr))
+		if err != nil {
+			fatal(err.Error())
+		}
 		if jengaPath == "" {
-			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
+			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file, use - for stdout")
 		}
 		debug("Add to jenga file: %s\n", jengaPath)
 		if source == "" {
 			fatal("Source is empty, add source path with flags: -s or --source-file")
 		}
-		if gzip && zlib {
-			fatal("Flag cannot contains both gizp [--compress-gzip | -g] and zlib [--compress-zlib | -z]")
+		if count(gzip, zlib, zstd, lz4, snappy, compressName != "") > 1 {
+			fatal("Flag cannot contains more than one of gizp [--compress-gzip | -g], zlib [--compress-zlib | -z], " +
+				"zstd [--compress-zstd], lz4 [--compress-lz4], snappy [--compress-snappy] and compress [--compress | -m]")
 		}
-		var blks jenga.Jenga
-		if gzip {
+		var opts []jengablk.BlocksV2Opt
+		if compressName != "" {
+			t, ok := compressor.GetType(compressName)
+			if !ok {
+				fatal("Compress type %s is not registered", compressName)
+			}
+			c, _ := compressor.NewCompressor(t)
+			debug("Jenga add with compress %s\n", compressName)
+			opts = append(opts, jengablk.BlockV2Opts.WithCompressor(c))
+		} else if gzip {
 			debug("Jenga add with compress gzip\n")
-			blks = jenga.NewJenga(jengaPath, jenga.V2Gzip())
+			opts = append(opts, jengablk.BlockV2Opts.WithGzip())
 		} else if zlib {
 			debug("Jenga add with compress zlib\n")
-			blks = jenga.NewJenga(jengaPath, jenga.V2Zlib())
+			opts = append(opts, jengablk.BlockV2Opts.WithZlib())
+		} else if zstd {
+			debug("Jenga add with compress zstd\n")
+			opts = append(opts, jengablk.BlockV2Opts.WithZstd(zstdOpts()...))
+		} else if lz4 {
+			debug("Jenga add with compress lz4\n")
+			opts = append(opts, jengablk.BlockV2Opts.WithLz4())
+		} else if snappy {
+			debug("Jenga add with compress snappy\n")
+			opts = append(opts, jengablk.BlockV2Opts.WithSnappy())
 		} else {
 			debug("Jenga add without compress\n")
-			blks = jenga.NewJenga(jengaPath, jenga.V2())
+		}
+		if len(noCompressExt) > 0 {
+			debug("Jenga add without compress for: %v\n", noCompressExt)
+			opts = append(opts, jengablk.BlockV2Opts.NoCompressExt(noCompressExt...))
+		}
+		if checksum {
+			debug("Jenga add with checksum\n")
+			opts = append(opts, jengablk.BlockV2Opts.WithChecksum())
+		}
+		if cipherName != "" {
+			c, ok := jengablk.GetCipher(cipherName)
+			if !ok {
+				fatal("Cipher %s is not supported, use aes-256-gcm or chacha20-poly1305", cipherName)
+			}
+			opts = append(opts, jengablk.BlockV2Opts.WithCipher(c))
+		}
+		if encryptKey {
+			if sec == nil {
+				fatal("Encrypt key need password file [--password-file] or key file [--key-file]")
+			}
+			debug("Jenga add with encrypted keys\n")
+			opts = append(opts, jengablk.BlockV2Opts.WithEncryptKey())
+		}
+		// 新建文件时记录权限、修改时间等元数据
+		opts = append(opts, jengablk.BlockV2Opts.WithMeta())
+		opts = append(opts, sec.opts()...)
+		var blks jenga.Jenga
+		if jengaPath == "-" {
+			// 写入标准输出，使用分块格式
+			debug("Jenga add to stdout\n")
+			opts = append([]jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithWriter(os.Stdout)}, opts...)
+			blks = jenga.NewJengaWithOpts(jenga.V3(opts...))
+		} else {
+			blks = jenga.NewJenga(jengaPath, jenga.V3(opts...))
 		}
 
-		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		err = blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
 		if err != nil {
 			fatal(err.Error())
 		}
@@ -67,18 +137,17 @@ var addCmd = &cobra.Command{
 			fatal("Source %s not exists", source)
 		}
 		if info.IsDir() {
-			addDir(blks, key, source)
+			addDir(blks, key, source, attrs)
 		} else {
 			if key == "" {
 				key = filepath.Base(source)
 			}
-			addFile(blks, key, source)
+			addFile(blks, key, source, attrs)
 		}
-		os.Exit(0)
 	},
 }
 
-func addDir(j jenga.Jenga, key, source string) {
+func addDir(j jenga.Jenga, key, source string, attrs map[string]string) {
 	source = filepath.Clean(source)
 	debug("Add dir: key %s dir: %s\n", key, source)
 	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
@@ -87,36 +156,45 @@ func addDir(j jenga.Jenga, key, source string) {
 		}
 		debug("Visit dir... found file: %s\n", path)
 		var fileKey string
-		if path == source {
+		if path == source || info.IsDir() {
 			return nil
 		}
 		fileKey, _ = filepath.Rel(source, path)
+		// key统一使用"/"分隔
+		fileKey = filepath.ToSlash(fileKey)
 		debug("File rel path: %s\n", fileKey)
 		if key != "" {
-			fileKey = filepath.Join(key, fileKey)
+			fileKey = filepath.ToSlash(filepath.Join(key, fileKey))
 		}
-		return addFile(j, fileKey, path)
+		return addFile(j, fileKey, path, attrs)
 	})
 	if err != nil {
 		fatal(err.Error())
 	}
-	os.Exit(0)
 }
 
-func addFile(j jenga.Jenga, key string, source string) error {
+func addFile(j jenga.Jenga, key string, source string, attrs map[string]string) error {
 	debug("Add file: key %s file path: %s \n", key, source)
 	info, err := os.Stat(source)
-	if err == nil {
-		if info.IsDir() {
-			fatal("File %s is a directory.", source)
-		}
+	if err != nil {
+		fatal(err.Error())
+	}
+	if info.IsDir() {
+		fatal("File %s is a directory.", source)
 	}
 	f, err := os.Open(source)
 	if err != nil {
 		fatal(err.Error())
 	}
 	defer f.Close()
-	n, err := j.Write(key, f)
+	meta := jenga.FileMeta(info)
+	meta.Attrs = attrs
+	n, err := j.WriteWithMeta(key, meta, f)
+	if jengaerr.NotSupportError.Equal(err) {
+		// 已存在的文件不包含元数据
+		debug("Jenga file does not support meta, add without meta\n")
+		n, err = j.Write(key, f)
+	}
 	if err != nil {
 		fatal(err.Error())
 	}
@@ -124,6 +202,46 @@ func addFile(j jenga.Jenga, key string, source string) error {
 	return nil
 }
 
+func parseAttrs(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]string, len(values))
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Attribute %s format error, must be key=value. ", v)
+		}
+		ret[v[:i]] = v[i+1:]
+	}
+	return ret, nil
+}
+
+func count(flags ...bool) int {
+	n := 0
+	for _, v := range flags {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+func zstdOpts() []compressor.ZstdOpt {
+	var ret []compressor.ZstdOpt
+	if level := addViper.GetInt(ParamZstdLevel); level > 0 {
+		ret = append(ret, compressor.ZstdOpts.Level(compressor.ZstdLevel(level)))
+	}
+	if path := addViper.GetString(ParamZstdDict); path != "" {
+		dict, err := ioutil.ReadFile(path)
+		if err != nil {
+			fatal(err.Error())
+		}
+		ret = append(ret, compressor.ZstdOpts.Dict(dict))
+	}
+	return ret
+}
+
 func init() {
 	rootCmd.AddCommand(addCmd)
 
@@ -139,4 +257,39 @@ func init() {
 
 	fs.BoolP(ParamJengaZlib, ParamShortJengaZlib, false, "Compress with zlib")
 	setValue(addViper, fs, ParamJengaZlib, ParamShortJengaZlib)
+
+	fs.Bool(ParamJengaZstd, false, "Compress with zstd")
+	setValue(addViper, fs, ParamJengaZstd)
+
+	fs.Int(ParamZstdLevel, 0, "Zstd compression level(1-22), use default level if 0")
+	setValue(addViper, fs, ParamZstdLevel)
+
+	fs.String(ParamZstdDict, "", "Zstd dictionary file(created by: zstd --train), stored in new jenga file")
+	setValue(addViper, fs, ParamZstdDict)
+
+	fs.Bool(ParamJengaLz4, false, "Compress with lz4")
+	setValue(addViper, fs, ParamJengaLz4)
+
+	fs.Bool(ParamJengaSnappy, false, "Compress with snappy")
+	setValue(addViper, fs, ParamJengaSnappy)
+
+	fs.StringP(ParamJengaCompress, ParamShortCompress, "", "Compress with registered compressor by name, such as: gzip, zlib, flate, lzw")
+	setValue(addViper, fs, ParamJengaCompress, ParamShortCompress)
+
+	fs.StringSlice(ParamNoCompressExt, nil, "Store data with these extensions without compress, such as: .jpg,.zip,.mp4")
+	setValue(addViper, fs, ParamNoCompressExt)
+
+	fs.BoolP(ParamJengaChecksum, ParamShortChecksum, false, "Record checksum of each data when create jenga file")
+	setValue(addViper, fs, ParamJengaChecksum, ParamShortChecksum)
+
+	fs.StringSliceP(ParamAttr, ParamShortAttr, nil, "Attribute of data, format: key=value")
+	setValue(addViper, fs, ParamAttr, ParamShortAttr)
+
+	addSecretFlags(addViper, fs)
+
+	fs.String(ParamCipher, "", "Cipher to encrypt data when create jenga file with password or key: aes-256-gcm(default), chacha20-poly1305")
+	setValue(addViper, fs, ParamCipher)
+
+	fs.Bool(ParamEncryptKey, false, "Encrypt keys and index as well when create jenga file with password or key")
+	setValue(addViper, fs, ParamEncryptKey)
 }
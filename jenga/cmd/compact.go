@@ -0,0 +1,130 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var compactViper = viper.New()
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Remove deleted and overwritten data from jenga file",
+	Run: func(cmd *cobra.Command, args []string) {
+		jengaPath := rootViper.GetString(ParamJengaFile)
+		target := compactViper.GetString(ParamTargetFile)
+		if jengaPath == "" {
+			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
+		}
+		debug("Compact jenga file: %s\n", jengaPath)
+		f, err := os.Open(jengaPath)
+		if err != nil {
+			fatal("jenga file %s open failed: %v. ", jengaPath, err)
+		}
+		h, err := jengablk.ReadFileHeader(f)
+		_ = f.Close()
+		if err != nil {
+			fatal("Read jenga file %s failed: %v. ", jengaPath, err)
+		}
+
+		// 使用相同的口令或密钥加密新文件
+		sec := readSecret(compactViper)
+		src := jengablk.NewV3BlockFile(jengaPath, sec.opts()...)
+		err = src.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			fatal(err.Error())
+		}
+
+		// 使用原文件的压缩器（包括字典）及格式特性，可直接复制压缩后的数据
+		opts := []jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithCompressor(src.Compressor())}
+		for _, v := range []struct {
+			feature uint16
+			opt     jengablk.BlocksV2Opt
+		}{
+			{jengablk.FeatureChecksum, jengablk.BlockV2Opts.WithChecksum()},
+			{jengablk.FeatureMeta, jengablk.BlockV2Opts.WithMeta()},
+			{jengablk.FeatureOriginSize, jengablk.BlockV2Opts.WithOriginSize()},
+			{jengablk.FeatureEntryCompress, jengablk.BlockV2Opts.WithEntryCompress()},
+			{jengablk.FeatureCommit, jengablk.BlockV2Opts.WithCommit()},
+		} {
+			if h.HasFeature(v.feature) {
+				opts = append(opts, v.opt)
+			}
+		}
+		if h.HasFeature(jengablk.FeatureEncrypt) {
+			opts = append(opts, sec.opts()...)
+			// key及索引同样加密，避免压缩后以明文保存
+			if h.HasFeature(jengablk.FeatureEncryptKey) {
+				opts = append(opts, jengablk.BlockV2Opts.WithEncryptKey())
+			}
+		}
+
+		inPlace := target == ""
+		if inPlace {
+			// 先写入同目录下的临时文件，完成后再替换原文件
+			tmp, err := ioutil.TempFile(filepath.Dir(jengaPath), filepath.Base(jengaPath)+".compact")
+			if err != nil {
+				_ = src.Close()
+				fatal(err.Error())
+			}
+			target = tmp.Name()
+			_ = tmp.Close()
+			_ = os.Remove(target)
+		} else if _, err := os.Stat(target); err == nil {
+			_ = src.Close()
+			fatal("Compact failed, file %s is exists", target)
+		}
+
+		var dst jengablk.JengaBlocks
+		if jengablk.IsV2Version(h.Version) {
+			dst = jengablk.NewV2BlockFile(target, opts...)
+		} else {
+			dst = jengablk.NewV3BlockFile(target, opts...)
+		}
+		err = dst.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			_ = src.Close()
+			fatal(err.Error())
+		}
+		err = src.Compact(dst)
+		if e := dst.Close(); e != nil && err == nil {
+			err = e
+		}
+		_ = src.Close()
+		if err != nil {
+			_ = os.Remove(target)
+			fatal(err.Error())
+		}
+		if inPlace {
+			err = os.Rename(target, jengaPath)
+			if err != nil {
+				_ = os.Remove(target)
+				fatal(err.Error())
+			}
+			target = jengaPath
+		}
+		if info, err := os.Stat(target); err == nil {
+			debug("Compact to %s success, size: %d\n", target, info.Size())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+
+	fs := compactCmd.Flags()
+	fs.StringP(ParamTargetFile, ParamShortTargetFile, "", "Target path to write, compact in place if empty")
+	setValue(compactViper, fs, ParamTargetFile, ParamShortTargetFile)
+	addSecretFlags(compactViper, fs)
+}
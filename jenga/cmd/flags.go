@@ -21,6 +21,26 @@ const (
 	ParamShortJengaGzip  = "g"
 	ParamJengaZlib       = "compress-zlib"
 	ParamShortJengaZlib  = "z"
+	ParamJengaZstd       = "compress-zstd"
+	ParamJengaLz4        = "compress-lz4"
+	ParamJengaSnappy     = "compress-snappy"
+	ParamZstdLevel       = "zstd-level"
+	ParamZstdDict        = "zstd-dict"
+	ParamJengaCompress   = "compress"
+	ParamShortCompress   = "m"
+	ParamNoCompressExt   = "no-compress-ext"
+	ParamPasswordFile    = "password-file"
+	ParamKeyFile         = "key-file"
+	ParamCipher          = "cipher"
+	ParamEncryptKey      = "encrypt-key"
+	ParamPrivateKeyFile  = "private-key-file"
+	ParamPublicKeyFile   = "pubkey"
+	ParamSigFile         = "sig-file"
+	ParamDetached        = "detached"
+	ParamJengaChecksum   = "checksum"
+	ParamShortChecksum   = "c"
+	ParamAttr            = "attr"
+	ParamShortAttr       = "a"
 	FlagTargetFile       = "flag.target.path"
 	ParamTargetFile      = "target-file"
 	FlagShortTargetFile  = "flag.short.target.path"
@@ -30,6 +50,8 @@ const (
 	ParamShortJengaFile  = "j"
 	ParamGetKey          = "key"
 	ParamShortGetKey     = "k"
+	ParamListLong        = "long"
+	ParamShortListLong   = "l"
 	ParamKeyFilter       = "key-regexp"
 	ParamShortKeyFilter  = "x"
 	ParamLogVerbose      = "verbose"
@@ -64,6 +86,7 @@ func output(format string, args ...interface{}) {
 func debug(format string, args ...interface{}) {
 	v := rootViper.GetBool(ParamShortLogVerbose)
 	if v {
-		_, _ = fmt.Fprintf(os.Stdout, format, args...)
+		// 输出至标准错误，避免与写入标准输出的数据混合
+		_, _ = fmt.Fprintf(os.Stderr, format, args...)
 	}
 }
@@ -20,6 +20,7 @@ import (
 	"github.com/xfali/jenga"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -33,6 +34,7 @@ var getCmd = &cobra.Command{
 		jengaPath := rootViper.GetString(ParamJengaFile)
 		key := getViper.GetString(ParamGetKey)
 		dest := getViper.GetString(ParamTargetFile)
+		sec := readSecret(getViper)
 		if jengaPath == "" {
 			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
 		}
@@ -44,7 +46,7 @@ var getCmd = &cobra.Command{
 		isDir := false
 		if err != nil {
 			if key == "" {
-				err = os.MkdirAll(dest, 0666)
+				err = os.MkdirAll(dest, 0755)
 				isDir = true
 				if err != nil {
 					fatal(err.Error())
@@ -54,7 +56,13 @@ var getCmd = &cobra.Command{
 			isDir = info.IsDir()
 		}
 
-		blks := jenga.NewJenga(jengaPath, jenga.V2())
+		if jengaPath == "-" {
+			// 从标准输入顺序读取
+			scanGet(sec.scanner(jenga.NewScanner(os.Stdin)), key, dest, isDir)
+			os.Exit(0)
+		}
+
+		blks := jenga.NewJenga(jengaPath, jenga.V3(sec.opts()...))
 		err = blks.Open(jenga.OpFlagReadOnly)
 		if err != nil {
 			fatal(err.Error())
@@ -62,7 +70,7 @@ var getCmd = &cobra.Command{
 		defer blks.Close()
 		if isDir {
 			if key != "" {
-				getFile(blks, key, filepath.Join(dest, key))
+				getFile(blks, key, keyPath(dest, key))
 			}
 			getDir(blks, dest)
 		} else {
@@ -76,7 +84,13 @@ func getDir(j jenga.Jenga, target string) {
 	debug("Get file to dir %s\n", target)
 	keys := j.KeyList()
 	for _, v := range keys {
-		getFile(j, v, filepath.Join(target, v))
+		path := keyPath(target, v)
+		// key以"/"分隔时还原目录结构
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			fatal(err.Error())
+		}
+		getFile(j, v, path)
 	}
 	os.Exit(0)
 }
@@ -91,13 +105,103 @@ func getFile(j jenga.Jenga, key string, target string) {
 	if err != nil {
 		fatal(err.Error())
 	}
-	defer f.Close()
 	n, err := j.Read(key, f)
+	_ = f.Close()
 	if err != nil {
 		fatal(err.Error())
 	} else {
 		debug("Get file: %s success, size: %d\n", target, n)
 	}
+	info, err := j.Stat(key)
+	if err == nil {
+		restoreMeta(target, info.EntryMeta)
+	}
+}
+
+// 顺序读取，以最后写入的数据为准：覆盖此前提取的同key文件，删除标记移除此前提取的文件
+func scanGet(s *jenga.Scanner, key string, dest string, isDir bool) {
+	if !isDir && key == "" {
+		fatal("Key is empty, add key with flags: -k or --key")
+	}
+	debug("Get file from stdin to %s\n", dest)
+	written := map[string]bool{}
+	for s.Next() {
+		info := s.Entry()
+		if key != "" && info.Key != key {
+			continue
+		}
+		target := dest
+		if isDir {
+			target = keyPath(dest, info.Key)
+		}
+		// 删除标记只移除此前写入的文件，不检查文件是否已存在
+		if s.Deleted() {
+			debug("Get file: key %s is deleted\n", info.Key)
+			if written[target] {
+				_ = os.Remove(target)
+				delete(written, target)
+			}
+			continue
+		}
+		if written[target] {
+			_ = os.Remove(target)
+			delete(written, target)
+		} else if _, err := os.Stat(target); err == nil {
+			fatal("Get file failed, file %s is exists", target)
+		}
+		err := os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			fatal(err.Error())
+		}
+		debug("Get file: key %s file to %s\n", info.Key, target)
+		f, err := os.OpenFile(target, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			fatal(err.Error())
+		}
+		n, err := s.Read(f)
+		_ = f.Close()
+		if err != nil {
+			fatal(err.Error())
+		}
+		debug("Get file: %s success, size: %d\n", target, n)
+		written[target] = true
+		restoreMeta(target, info.EntryMeta)
+	}
+	if s.Err() != nil {
+		fatal(s.Err().Error())
+	}
+	if key != "" && len(written) == 0 {
+		fatal("Get file failed, key %s not found", key)
+	}
+}
+
+// 将key转换为target目录下的路径，key为绝对路径或超出target（如"../x"）时退出
+func keyPath(target, key string) string {
+	name := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || name == "." || name == ".." ||
+		strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		fatal("Get file failed, key %s is outside target %s", key, target)
+	}
+	return filepath.Join(target, name)
+}
+
+// 恢复权限、修改时间及所有者（仅root用户）
+func restoreMeta(target string, meta jenga.EntryMeta) {
+	if meta.Mode != 0 {
+		if err := os.Chmod(target, meta.Mode.Perm()); err != nil {
+			debug("Restore mode of %s failed: %v\n", target, err)
+		}
+	}
+	if !meta.ModTime.IsZero() {
+		if err := os.Chtimes(target, meta.ModTime, meta.ModTime); err != nil {
+			debug("Restore modify time of %s failed: %v\n", target, err)
+		}
+	}
+	if meta.Uid >= 0 && meta.Gid >= 0 && meta.Mode != 0 && os.Geteuid() == 0 {
+		if err := os.Chown(target, meta.Uid, meta.Gid); err != nil {
+			debug("Restore owner of %s failed: %v\n", target, err)
+		}
+	}
 }
 
 func init() {
@@ -108,4 +212,5 @@ func init() {
 	setValue(getViper, fs, ParamGetKey, ParamShortGetKey)
 	fs.StringP(ParamTargetFile, ParamShortTargetFile, "", "Target path to write")
 	setValue(getViper, fs, ParamTargetFile, ParamShortTargetFile)
+	addSecretFlags(getViper, fs)
 }
@@ -6,12 +6,18 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
 	"github.com/xfali/jenga/blk"
 	"github.com/xfali/jenga/compressor"
 	"os"
+	"strings"
 )
 
+var infoViper = viper.New()
+
 // listCmd represents the list command
 var info = &cobra.Command{
 	Use:   "info",
@@ -35,10 +41,36 @@ var info = &cobra.Command{
 		output("Version:\t%d\n", h.Version)
 		output("Data format:\t%d (%s)\n", h.DataFormat, compressor.GetName(h.DataFormat))
 		output("Reserve:\t%d\n", h.Reserve)
+		output("Features:\t%s\n", strings.Join(jengablk.GetFeatureNames(h.Reserve), ","))
+		if h.HasFeature(jengablk.FeatureEncrypt) {
+			output("Encrypted:\tyes\n")
+		}
+		if h.HasFeature(jengablk.FeatureEncryptKey) {
+			output("Encrypted keys:\tyes\n")
+		}
+		// 加密的文件需指定口令或密钥才能获得数据个数
+		sec := readSecret(infoViper)
+		if h.Version != jengablk.BlkFileVersion && (sec != nil || !h.HasFeature(jengablk.FeatureEncrypt)) {
+			blks := jenga.NewJenga(jengaPath, jenga.V3(sec.opts()...))
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				fatal(err.Error())
+			}
+			output("Entries:\t%d\n", len(blks.KeyList()))
+			if h.Version == jengablk.BlkFileV3Version {
+				root, err := blks.MerkleRoot()
+				if err != nil {
+					fatal(err.Error())
+				}
+				output("Merkle root:\t%s\n", hex.EncodeToString(root))
+			}
+			_ = blks.Close()
+		}
 		os.Exit(0)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(info)
+	addSecretFlags(infoViper, info.Flags())
 }
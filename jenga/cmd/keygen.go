@@ -0,0 +1,57 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+)
+
+var keygenViper = viper.New()
+
+// keygenCmd represents the keygen command
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate ed25519 key pair for sign and verify",
+	Run: func(cmd *cobra.Command, args []string) {
+		target := keygenViper.GetString(ParamTargetFile)
+		if target == "" {
+			fatal("Target path is empty, add target with flags: -f or --target-file")
+		}
+		pubPath := target + ".pub"
+		for _, p := range []string{target, pubPath} {
+			if _, err := os.Stat(p); err == nil {
+				fatal("Generate key failed, file %s is exists", p)
+			}
+		}
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fatal(err.Error())
+		}
+		err = ioutil.WriteFile(target, []byte(hex.EncodeToString(priv)+"\n"), 0600)
+		if err != nil {
+			fatal(err.Error())
+		}
+		err = ioutil.WriteFile(pubPath, []byte(hex.EncodeToString(pub)+"\n"), 0644)
+		if err != nil {
+			fatal(err.Error())
+		}
+		output("Private key: %s\nPublic key: %s\n", target, pubPath)
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+	fs := keygenCmd.Flags()
+	fs.StringP(ParamTargetFile, ParamShortTargetFile, "", "Path of private key, public key is written to <path>.pub")
+	setValue(keygenViper, fs, ParamTargetFile, ParamShortTargetFile)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/xfali/jenga"
 	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/compressor"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -33,16 +34,16 @@ var listCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		jengaPath := rootViper.GetString(ParamJengaFile)
 		regexp := listViper.GetString(ParamKeyFilter)
+		long := listViper.GetBool(ParamListLong)
+		opts := readSecret(listViper).opts()
 		if jengaPath == "" {
 			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
 		}
 		debug("Jenga file: %s\n", jengaPath)
-		var blks jenga.Jenga
 		if regexp != "" {
-			blks = jenga.NewJenga(jengaPath, jenga.V2(jengablk.BlockV2Opts.KeyMatch(regexp)))
-		} else {
-			blks = jenga.NewJenga(jengaPath, jenga.V2())
+			opts = append(opts, jengablk.BlockV2Opts.KeyMatch(regexp))
 		}
+		blks := jenga.NewJenga(jengaPath, jenga.V3(opts...))
 
 		err := blks.Open(jenga.OpFlagReadOnly)
 		if err != nil {
@@ -51,15 +52,38 @@ var listCmd = &cobra.Command{
 		defer blks.Close()
 		keys := blks.KeyList()
 		for _, v := range keys {
-			output("%s\n", v)
+			if long {
+				printEntry(blks, v)
+			} else {
+				output("%s\n", v)
+			}
 		}
 		os.Exit(0)
 	},
 }
 
+// 输出：KEY	SIZE	ORIGIN SIZE	RATIO	COMPRESS
+func printEntry(j jenga.Jenga, key string) {
+	info, err := j.Stat(key)
+	if err != nil {
+		fatal(err.Error())
+	}
+	name := compressor.GetName(info.Compress.Value())
+	if info.OriginSize == jengablk.BlkHeaderUnknownSize {
+		output("%s\t%d\t-\t-\t%s\n", key, info.Size, name)
+	} else if info.OriginSize == 0 {
+		output("%s\t%d\t%d\t-\t%s\n", key, info.Size, info.OriginSize, name)
+	} else {
+		output("%s\t%d\t%d\t%.2f%%\t%s\n", key, info.Size, info.OriginSize, float64(info.Size)*100/float64(info.OriginSize), name)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
 	fs := listCmd.Flags()
 	fs.StringP(ParamKeyFilter, ParamShortKeyFilter, "", "key filter")
 	setValue(listViper, fs, ParamKeyFilter, ParamShortKeyFilter)
+	fs.BoolP(ParamListLong, ParamShortListLong, false, "Print compressed size, origin size, compress ratio and compress type of each data")
+	setValue(listViper, fs, ParamListLong, ParamShortListLong)
+	addSecretFlags(listViper, fs)
 }
@@ -0,0 +1,54 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"os"
+)
+
+var recoverViper = viper.New()
+
+// recoverCmd represents the recover command
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Remove torn tail left by interrupted write from jenga file",
+	Run: func(cmd *cobra.Command, args []string) {
+		jengaPath := rootViper.GetString(ParamJengaFile)
+		if jengaPath == "" {
+			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
+		}
+		debug("Recover jenga file: %s\n", jengaPath)
+		f, err := os.Open(jengaPath)
+		if err != nil {
+			fatal("jenga file %s open failed: %v. ", jengaPath, err)
+		}
+		h, err := jengablk.ReadFileHeader(f)
+		_ = f.Close()
+		if err != nil {
+			fatal("Read jenga file %s failed: %v. ", jengaPath, err)
+		}
+		if !h.HasFeature(jengablk.FeatureCommit) {
+			fatal("Jenga file %s without commit feature, cannot detect torn tail", jengaPath)
+		}
+
+		sec := readSecret(recoverViper)
+		n, err := jenga.Recover(jengaPath, sec.opts()...)
+		if err != nil {
+			fatal(err.Error())
+		}
+		output("Dropped %d bytes\n", n)
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+	addSecretFlags(recoverViper, recoverCmd.Flags())
+}
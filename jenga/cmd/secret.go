@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"io/ioutil"
+	"strings"
+)
+
+// 从--password-file或--key-file读取的口令或密钥
+type secret struct {
+	passphrase string
+	key        []byte
+}
+
+// 读取口令或密钥，均未指定时返回nil
+func readSecret(v *viper.Viper) *secret {
+	passwordFile := v.GetString(ParamPasswordFile)
+	keyFile := v.GetString(ParamKeyFile)
+	if passwordFile != "" && keyFile != "" {
+		fatal("Flag cannot contains both password file [--password-file] and key file [--key-file]")
+	}
+	if passwordFile != "" {
+		d, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			fatal(err.Error())
+		}
+		// 忽略文件末尾的换行
+		passphrase := strings.TrimRight(string(d), "\r\n")
+		if passphrase == "" {
+			fatal("Password file %s is empty", passwordFile)
+		}
+		debug("Jenga with password file: %s\n", passwordFile)
+		return &secret{passphrase: passphrase}
+	}
+	if keyFile != "" {
+		d, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			fatal(err.Error())
+		}
+		// 原始密钥或十六进制文本
+		key := d
+		if len(d) != jengablk.EncryptKeySize {
+			key, err = hex.DecodeString(string(bytes.TrimSpace(d)))
+			if err != nil || len(key) != jengablk.EncryptKeySize {
+				fatal("Key file %s must contain %d bytes key or %d hex characters", keyFile, jengablk.EncryptKeySize, jengablk.EncryptKeySize*2)
+			}
+		}
+		debug("Jenga with key file: %s\n", keyFile)
+		return &secret{key: key}
+	}
+	return nil
+}
+
+func (s *secret) opts() []jengablk.BlocksV2Opt {
+	if s == nil {
+		return nil
+	}
+	if s.key != nil {
+		return []jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithKey(s.key)}
+	}
+	return []jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithPassphrase(s.passphrase)}
+}
+
+func (s *secret) scanner(sc *jenga.Scanner) *jenga.Scanner {
+	if s == nil {
+		return sc
+	}
+	if s.key != nil {
+		return sc.WithKey(s.key)
+	}
+	return sc.WithPassphrase(s.passphrase)
+}
+
+func addSecretFlags(v *viper.Viper, fs *pflag.FlagSet) {
+	fs.String(ParamPasswordFile, "", "File contains password to encrypt or decrypt data")
+	setValue(v, fs, ParamPasswordFile)
+
+	fs.String(ParamKeyFile, "", "File contains 32 bytes key (raw or hex) to encrypt or decrypt data")
+	setValue(v, fs, ParamKeyFile)
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
+	"io/ioutil"
+	"os"
+)
+
+var signViper = viper.New()
+
+// signCmd represents the sign command
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign jenga file with ed25519 private key",
+	Run: func(cmd *cobra.Command, args []string) {
+		jengaPath := rootViper.GetString(ParamJengaFile)
+		if jengaPath == "" {
+			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
+		}
+		keyFile := signViper.GetString(ParamPrivateKeyFile)
+		if keyFile == "" {
+			fatal("Private key is empty, add private key with flags: --private-key-file")
+		}
+		priv := readPrivateKey(keyFile)
+		detached := signViper.GetBool(ParamDetached)
+		sigFile := signViper.GetString(ParamSigFile)
+		if sigFile != "" {
+			detached = true
+		} else if detached {
+			sigFile = jengaPath + ".sig"
+		}
+		debug("Sign jenga file: %s\n", jengaPath)
+
+		sec := readSecret(signViper)
+		m, err := jenga.Sign(jengaPath, priv, !detached, sec.opts()...)
+		if err != nil {
+			fatal(err.Error())
+		}
+		if detached {
+			err = ioutil.WriteFile(sigFile, m.Bytes(), 0644)
+			if err != nil {
+				fatal(err.Error())
+			}
+			output("Signature written to %s\n", sigFile)
+		}
+		output("Signed %d bytes, sha256: %s\n", m.Size, hex.EncodeToString(m.Digest[:]))
+		os.Exit(0)
+	},
+}
+
+// 读取ed25519私钥：64字节私钥或32字节种子（原始数据或十六进制文本）
+func readPrivateKey(path string) ed25519.PrivateKey {
+	d := readKeyFile(path)
+	switch len(d) {
+	case ed25519.PrivateKeySize:
+		return d
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(d)
+	}
+	fatal("Private key file %s must contain %d bytes key or %d bytes seed", path, ed25519.PrivateKeySize, ed25519.SeedSize)
+	return nil
+}
+
+// 读取ed25519公钥：32字节（原始数据或十六进制文本）
+func readPublicKey(path string) ed25519.PublicKey {
+	d := readKeyFile(path)
+	if len(d) != ed25519.PublicKeySize {
+		fatal("Public key file %s must contain %d bytes key", path, ed25519.PublicKeySize)
+	}
+	return d
+}
+
+func readKeyFile(path string) []byte {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if key, err := hex.DecodeString(string(bytes.TrimSpace(d))); err == nil {
+		return key
+	}
+	return d
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	fs := signCmd.Flags()
+	fs.String(ParamPrivateKeyFile, "", "File contains ed25519 private key (raw or hex, 64 bytes key or 32 bytes seed)")
+	setValue(signViper, fs, ParamPrivateKeyFile)
+
+	fs.Bool(ParamDetached, false, "Write detached signature to <jenga file>.sig instead of embedding it (V3 format only)")
+	setValue(signViper, fs, ParamDetached)
+
+	fs.String(ParamSigFile, "", "Path of detached signature, implies --detached")
+	setValue(signViper, fs, ParamSigFile)
+
+	addSecretFlags(signViper, fs)
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package cmd
+
+import (
+	"encoding/hex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xfali/jenga"
+	"io/ioutil"
+	"os"
+)
+
+var verifyViper = viper.New()
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify signature of jenga file with ed25519 public key",
+	Run: func(cmd *cobra.Command, args []string) {
+		jengaPath := rootViper.GetString(ParamJengaFile)
+		if jengaPath == "" {
+			fatal("Jenga path is empty, add jenga with flags: -j or --jenga-file")
+		}
+		keyFile := verifyViper.GetString(ParamPublicKeyFile)
+		if keyFile == "" {
+			fatal("Public key is empty, add public key with flags: --pubkey")
+		}
+		pub := readPublicKey(keyFile)
+		var sig []byte
+		sigFile := verifyViper.GetString(ParamSigFile)
+		if sigFile != "" {
+			var err error
+			sig, err = ioutil.ReadFile(sigFile)
+			if err != nil {
+				fatal(err.Error())
+			}
+			debug("Detached signature: %s\n", sigFile)
+		}
+		debug("Verify jenga file: %s\n", jengaPath)
+
+		sec := readSecret(verifyViper)
+		m, err := jenga.Verify(jengaPath, pub, sig, sec.opts()...)
+		if err != nil {
+			fatal(err.Error())
+		}
+		output("Signature OK, signed %d bytes, sha256: %s\n", m.Size, hex.EncodeToString(m.Digest[:]))
+		os.Exit(0)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	fs := verifyCmd.Flags()
+	fs.String(ParamPublicKeyFile, "", "File contains ed25519 public key (raw or hex)")
+	setValue(verifyViper, fs, ParamPublicKeyFile)
+
+	fs.String(ParamSigFile, "", "Path of detached signature, use embedded signature if empty")
+	setValue(verifyViper, fs, ParamSigFile)
+
+	addSecretFlags(verifyViper, fs)
+}
@@ -14,6 +14,8 @@ import (
 type blkJenga struct {
 	flag OpenFlag
 	blk  jengablk.JengaBlocks
+	// 打开时追加的选项（如WithPassphrase），与选项的顺序无关
+	blkOpts []jengablk.BlocksV2Opt
 }
 
 type Opt func(j *blkJenga, uri string)
@@ -41,6 +43,9 @@ func NewJengaWithOpts(opts ...Opt) *blkJenga {
 
 func (jenga *blkJenga) Open(flag OpenFlag) error {
 	jenga.flag = flag
+	if len(jenga.blkOpts) > 0 && !jengablk.ApplyBlocksV2Opts(jenga.blk, jenga.blkOpts...) {
+		return jengaerr.NotSupportError.Format("Jenga format except V2 and V3", "encryption")
+	}
 	return jenga.blk.Open(flag)
 }
 
@@ -63,6 +68,20 @@ func (jenga *blkJenga) Write(key string, r io.Reader) (size int64, err error) {
 	return jenga.blk.WriteBlock(key, r)
 }
 
+func (jenga *blkJenga) WriteWithMeta(key string, meta EntryMeta, r io.Reader) (size int64, err error) {
+	if !jenga.flag.CanWrite() {
+		return 0, jengaerr.WriteFlagError
+	}
+	return jenga.blk.WriteBlockWithMeta(key, meta, r)
+}
+
+func (jenga *blkJenga) Delete(key string) error {
+	if !jenga.flag.CanWrite() {
+		return jengaerr.WriteFlagError
+	}
+	return jenga.blk.DeleteBlock(key)
+}
+
 func (jenga *blkJenga) Read(path string, w io.Writer) (int64, error) {
 	if !jenga.flag.CanRead() {
 		return 0, jengaerr.ReadFlagError
@@ -70,6 +89,29 @@ func (jenga *blkJenga) Read(path string, w io.Writer) (int64, error) {
 	return jenga.blk.ReadBlockByKey(path, w)
 }
 
+func (jenga *blkJenga) OpenReader(key string) (io.ReadCloser, error) {
+	if !jenga.flag.CanRead() {
+		return nil, jengaerr.ReadFlagError
+	}
+	return jenga.blk.OpenBlock(key)
+}
+
+func (jenga *blkJenga) OpenSection(key string) (*io.SectionReader, error) {
+	if !jenga.flag.CanRead() {
+		return nil, jengaerr.ReadFlagError
+	}
+	return jenga.blk.OpenBlockSection(key)
+}
+
+func (jenga *blkJenga) Stat(key string) (EntryInfo, error) {
+	return jenga.blk.StatBlock(key)
+}
+
+func (jenga *blkJenga) Exists(key string) bool {
+	_, err := jenga.blk.StatBlock(key)
+	return err == nil
+}
+
 func (jenga *blkJenga) Close() (err error) {
 	return jenga.blk.Close()
 }
@@ -94,6 +136,17 @@ func V2(opts ...jengablk.BlocksV2Opt) Opt {
 	}
 }
 
+// V3格式在V2基础上于文件尾部写入索引，可兼容读写V2格式文件
+func V3(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		if uri != "" {
+			j.blk = jengablk.NewV3BlockFile(uri, opts...)
+		} else {
+			j.blk = jengablk.NewV3Blocks(opts...)
+		}
+	}
+}
+
 func V2Gzip(opts ...jengablk.BlocksV2Opt) Opt {
 	return func(j *blkJenga, uri string) {
 		var newOpt []jengablk.BlocksV2Opt
@@ -116,6 +169,61 @@ func V2Zlib(opts ...jengablk.BlocksV2Opt) Opt {
 	}
 }
 
+func V2Zstd(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		var newOpt []jengablk.BlocksV2Opt
+		if uri != "" {
+			newOpt = append(newOpt, jengablk.BlockV2Opts.LocalFile(uri))
+		}
+		newOpt = append(newOpt, jengablk.BlockV2Opts.WithZstd())
+		j.blk = jengablk.NewV2Blocks(append(newOpt, opts...)...)
+	}
+}
+
+func V2Lz4(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		var newOpt []jengablk.BlocksV2Opt
+		if uri != "" {
+			newOpt = append(newOpt, jengablk.BlockV2Opts.LocalFile(uri))
+		}
+		newOpt = append(newOpt, jengablk.BlockV2Opts.WithLz4())
+		j.blk = jengablk.NewV2Blocks(append(newOpt, opts...)...)
+	}
+}
+
+func V2Snappy(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		var newOpt []jengablk.BlocksV2Opt
+		if uri != "" {
+			newOpt = append(newOpt, jengablk.BlockV2Opts.LocalFile(uri))
+		}
+		newOpt = append(newOpt, jengablk.BlockV2Opts.WithSnappy())
+		j.blk = jengablk.NewV2Blocks(append(newOpt, opts...)...)
+	}
+}
+
+func V2Flate(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		var newOpt []jengablk.BlocksV2Opt
+		if uri != "" {
+			newOpt = append(newOpt, jengablk.BlockV2Opts.LocalFile(uri))
+		}
+		newOpt = append(newOpt, jengablk.BlockV2Opts.WithFlate())
+		j.blk = jengablk.NewV2Blocks(append(newOpt, opts...)...)
+	}
+}
+
+func V2Lzw(opts ...jengablk.BlocksV2Opt) Opt {
+	return func(j *blkJenga, uri string) {
+		var newOpt []jengablk.BlocksV2Opt
+		if uri != "" {
+			newOpt = append(newOpt, jengablk.BlockV2Opts.LocalFile(uri))
+		}
+		newOpt = append(newOpt, jengablk.BlockV2Opts.WithLzw())
+		j.blk = jengablk.NewV2Blocks(append(newOpt, opts...)...)
+	}
+}
+
 func WithBlocks(blk jengablk.JengaBlocks) Opt {
 	return func(j *blkJenga, uri string) {
 		j.blk = blk
@@ -127,3 +235,19 @@ func WithFactory(factory func(uri string) jengablk.JengaBlocks) Opt {
 		j.blk = factory(uri)
 	}
 }
+
+// 使用口令加密数据（压缩后加密），仅支持V2、V3格式，其他格式打开时返回jengaerr.NotSupportError。
+// 打开已加密的文件时需指定相同的口令
+func WithPassphrase(passphrase string) Opt {
+	return func(j *blkJenga, uri string) {
+		j.blkOpts = append(j.blkOpts, jengablk.BlockV2Opts.WithPassphrase(passphrase))
+	}
+}
+
+// 使用jengablk.EncryptKeySize字节的密钥加密数据（压缩后加密），仅支持V2、V3格式，其他格式打开时返回jengaerr.NotSupportError。
+// 打开已加密的文件时需指定相同的密钥
+func WithKey(key []byte) Opt {
+	return func(j *blkJenga, uri string) {
+		j.blkOpts = append(j.blkOpts, jengablk.BlockV2Opts.WithKey(key))
+	}
+}
@@ -14,15 +14,30 @@ var (
 	OpenRWFlagError           = newError(1002, "%s format flag cannot contains both OpFlagReadOnly and OpFlagWriteOnly. ")
 	JengaBrokenError          = newError(1003, "Jenga file format not match, maybe broken. ")
 	OpenFlagError             = newError(1004, "Cannot open with flag %d. ")
+	NotSupportError           = newError(1005, "%s does not support %s. ")
 	DataFormatNotSupportError = newError(1101, "Cannot support format type: %d. ")
 	VersionNotSupportError    = newError(1102, "Version: %d not support, expect version: %d. ")
+	CompressorReadOnlyError   = newError(1103, "Compressor %s is read-only, cannot compress data. ")
+	DecryptKeyError           = newError(1104, "Decrypt failed, wrong key or passphrase. ")
+	EncryptKeyRequiredError   = newError(1105, "Jenga file is encrypted, need key or passphrase. ")
+	EncryptKeySizeError       = newError(1106, "Encrypt key must be %d bytes, but got %d bytes. ")
+	CipherNotSupportError     = newError(1107, "Cannot support cipher type: %d. ")
+	DecryptKeyNameError       = newError(1108, "Decrypt key name failed, maybe broken. ")
+	SignatureNotFoundError    = newError(1109, "Jenga file is not signed. ")
+	SignatureVerifyError      = newError(1110, "Jenga file signature verify failed: %s. ")
+	SignKeyError              = newError(1111, "Sign key must be ed25519 private key. ")
+	MerkleNotFoundError       = newError(1112, "Merkle tree only support V3 format. ")
+	MerkleVerifyError         = newError(1113, "Merkle proof verify failed: %s. ")
+	FeatureNotSupportError    = newError(1114, "Cannot support feature: %s. ")
 	OpenFileError             = newError(1201, "Cannot open file %s with flag %d. ")
+	FileTruncateError         = newError(1202, "File cannot be truncated. ")
 
 	WriteFlagError            = newError(2001, "Jenga write failed. Need open with OpFlagWriteOnly flag. ")
 	WriteFailedError          = newError(2002, "Jenga write failed. ")
 	WriteSizeNotMatchError    = newError(2003, "Write size is not match then Header Size! ")
 	WriteExistKeyError        = newError(2011, "Block with key %s have been written. ")
 	WriteKeyFilteredError     = newError(2012, "Key is filtered, cannot be add. ")
+	WriteKeySizeError         = newError(2013, "Key length %d exceeds limit %d. ")
 	WriteWithoutSizeFuncError = newError(2021, "%s need a block size map function. ")
 	WriteSizeError            = newError(2022, "blkJenga param size %d is Illegal, it must be actual reader data size. ")
 
@@ -31,6 +46,9 @@ var (
 	ReadBlockVarintFailedError = newError(3005, "Cannot parse varint. ")
 	ReadKeySizeNotMatchError   = newError(3011, "Read key length is not match record size! ")
 	ReadNodeSizeNotMatchError  = newError(3012, "Read size is not match the Node Size! ")
+	ReadChecksumNotMatchError  = newError(3013, "Block with key: %s checksum not match, maybe broken. ")
+	ReadDecryptFailedError     = newError(3014, "Block with key: %s decrypt failed, maybe broken. ")
+	ReadCommitNotMatchError    = newError(3015, "Block at offset: %d is not committed, maybe broken. ")
 	ReadKeyNotFoundError       = newError(3021, "Block with key: %s not found. ")
 
 	TarNotExistsError        = newError(13001, "Tar file %s not exists. ")
@@ -0,0 +1,46 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/jengaerr"
+	"io"
+)
+
+// Merkle证明：实体在Merkle树中的位置及由叶子至根的路径
+type MerkleProof = jengablk.MerkleProof
+
+type merkleBlocks interface {
+	MerkleRoot() ([]byte, error)
+	Proof(key string) (*jengablk.MerkleProof, error)
+}
+
+// 获得全部实体（每个key最后写入且未删除的实体）的Merkle根，仅支持V3格式
+func (jenga *blkJenga) MerkleRoot() ([]byte, error) {
+	if b, ok := jenga.blk.(merkleBlocks); ok {
+		return b.MerkleRoot()
+	}
+	return nil, jengaerr.MerkleNotFoundError
+}
+
+// 获得key的Merkle证明，可与数据一同分发，持有Merkle根的客户端使用VerifyEntry校验单个实体
+func (jenga *blkJenga) Proof(key string) (*MerkleProof, error) {
+	if b, ok := jenga.blk.(merkleBlocks); ok {
+		return b.Proof(key)
+	}
+	return nil, jengaerr.MerkleNotFoundError
+}
+
+// 使用Merkle根及key的Merkle证明校验提取的原始数据，不匹配时返回jengaerr.MerkleVerifyError
+func VerifyEntry(key string, data io.Reader, root []byte, proof *MerkleProof) error {
+	return jengablk.VerifyEntry(key, data, root, proof)
+}
+
+// 解析Merkle证明（MerkleProof.Bytes）
+func ParseMerkleProof(data []byte) (*MerkleProof, error) {
+	return jengablk.ParseMerkleProof(data)
+}
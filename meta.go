@@ -0,0 +1,20 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"os"
+)
+
+// 从文件信息获得元数据：权限、修改时间及所有者（无法获得所有者时Uid、Gid为-1）
+func FileMeta(info os.FileInfo) EntryMeta {
+	ret := EntryMeta{
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	ret.Uid, ret.Gid = fileOwner(info)
+	return ret
+}
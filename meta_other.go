@@ -0,0 +1,17 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package jenga
+
+import (
+	"os"
+)
+
+func fileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
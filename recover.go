@@ -0,0 +1,23 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"github.com/xfali/jenga/blk"
+)
+
+// 以写入模式打开jenga文件，移除追加写入中断（如进程异常退出）时未提交的尾部，返回移除的数据大小。
+// 文件需包含FeatureCommit（V3格式默认包含）
+// opts：打开文件的选项，如加密文件的口令或密钥
+func Recover(uri string, opts ...jengablk.BlocksV2Opt) (int64, error) {
+	b := jengablk.NewV3BlockFile(uri, opts...)
+	err := b.Open(OpFlagWriteOnly)
+	if err != nil {
+		return 0, err
+	}
+	torn := b.Torn()
+	return torn, b.Close()
+}
@@ -0,0 +1,19 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"github.com/xfali/jenga/blk"
+	"io"
+)
+
+// 顺序读取V2、V3格式jenga文件的Scanner
+type Scanner = jengablk.Scanner
+
+// 从r顺序读取jenga文件，无需Seek，可用于管道、标准输入、网络连接等
+func NewScanner(r io.Reader) *Scanner {
+	return jengablk.NewScanner(r)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package jenga
+
+import (
+	"crypto/ed25519"
+	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/jengaerr"
+)
+
+// 签名清单：文件头及全部实体的摘要及ed25519签名
+type Manifest = jengablk.Manifest
+
+// 打开时使用公钥校验签名（V3文件尾部记录的签名或WithDetachedSignature指定的签名），
+// 未签名或数据被篡改时打开失败。需在V2、V3等选项之后
+func RequireSignature(pub ed25519.PublicKey) Opt {
+	return func(j *blkJenga, uri string) {
+		if j.blk == nil || !jengablk.ApplyBlocksV2Opts(j.blk, jengablk.BlockV2Opts.RequireSignature(pub)) {
+			panic("RequireSignature only support V2 or V3 format! ")
+		}
+	}
+}
+
+// 打开时使用单独保存的签名（如.sig文件）校验，需同时使用RequireSignature
+func WithDetachedSignature(sig []byte) Opt {
+	return func(j *blkJenga, uri string) {
+		if j.blk == nil || !jengablk.ApplyBlocksV2Opts(j.blk, jengablk.BlockV2Opts.WithDetachedSignature(sig)) {
+			panic("WithDetachedSignature only support V2 or V3 format! ")
+		}
+	}
+}
+
+// 计算文件头及全部实体的摘要并签名，返回的签名可单独保存（Manifest.Bytes）。
+// embed为true时签名同时写入文件尾部，仅支持V3格式
+// opts：打开文件的选项，如加密文件的口令或密钥
+func Sign(uri string, priv ed25519.PrivateKey, embed bool, opts ...jengablk.BlocksV2Opt) (*Manifest, error) {
+	b := jengablk.NewV3BlockFile(uri, opts...)
+	flag := OpFlagReadOnly
+	if embed {
+		flag = OpFlagWriteOnly
+	}
+	err := b.Open(flag)
+	if err != nil {
+		return nil, err
+	}
+	m, err := b.Sign(priv)
+	if err == nil && embed && b.Manifest() == nil {
+		err = jengaerr.NotSupportError.Format("V2 format", "embedded signature")
+	}
+	cerr := b.Close()
+	if err != nil {
+		return nil, err
+	}
+	return m, cerr
+}
+
+// 使用公钥校验签名，detached为nil时使用文件尾部记录的签名
+// opts：打开文件的选项，如加密文件的口令或密钥
+func Verify(uri string, pub ed25519.PublicKey, detached []byte, opts ...jengablk.BlocksV2Opt) (*Manifest, error) {
+	var m *Manifest
+	if detached != nil {
+		var err error
+		m, err = jengablk.ParseManifest(detached)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b := jengablk.NewV3BlockFile(uri, opts...)
+	err := b.Open(OpFlagReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	if m == nil {
+		m = b.Manifest()
+	}
+	return m, b.Verify(pub, m)
+}
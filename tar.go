@@ -8,12 +8,19 @@ package jenga
 import (
 	"archive/tar"
 	"errors"
+	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/compressor"
 	"github.com/xfali/jenga/jengaerr"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// 自定义属性在PAX records中的前缀
+const tarAttrPrefix = "JENGA.attr."
+
 type tarJenga struct {
 	path string
 	file *os.File
@@ -29,8 +36,9 @@ func NewTar(tarPath string) *tarJenga {
 }
 
 func (jenga *tarJenga) Open(flag OpenFlag) error {
+	// tar格式只能顺序读写，不支持读写模式（OpFlagReadWrite）
 	if flag.CanWrite() && flag.CanRead() {
-		return jengaerr.OpenRWFlagError.Format("Tar")
+		return jengaerr.NotSupportError.Format("Tar format", "read-write mode")
 	}
 	jenga.flag = flag
 
@@ -94,6 +102,15 @@ func (jenga *tarJenga) Sync() error {
 }
 
 func (jenga *tarJenga) Write(path string, r io.Reader) (int64, error) {
+	return jenga.writeFile(path, nil)
+}
+
+// 元数据写入tar header，自定义属性记录于PAX records
+func (jenga *tarJenga) WriteWithMeta(path string, meta EntryMeta, r io.Reader) (int64, error) {
+	return jenga.writeFile(path, &meta)
+}
+
+func (jenga *tarJenga) writeFile(path string, meta *EntryMeta) (int64, error) {
 	if !jenga.flag.CanWrite() {
 		return 0, jengaerr.WriteFlagError
 	}
@@ -102,6 +119,9 @@ func (jenga *tarJenga) Write(path string, r io.Reader) (int64, error) {
 		if err != nil {
 			return 0, jengaerr.WriteFailedError
 		}
+		if meta != nil {
+			setTarMeta(hdr, meta)
+		}
 		err = jenga.w.WriteHeader(hdr)
 		if err != nil {
 			return 0, jengaerr.WriteFailedError
@@ -122,11 +142,16 @@ func (jenga *tarJenga) Write(path string, r io.Reader) (int64, error) {
 	}
 }
 
+func (jenga *tarJenga) Delete(key string) error {
+	return jengaerr.NotSupportError.Format("Tar", "Delete")
+}
+
 func (jenga *tarJenga) Read(path string, w io.Writer) (int64, error) {
 	if !jenga.flag.CanRead() {
 		return 0, jengaerr.ReadFlagError
 	}
-	r := tar.NewReader(jenga.file)
+	// 使用ReadAt读取，不改变文件位置，可并发调用
+	r := tar.NewReader(io.NewSectionReader(jenga.file, 0, math.MaxInt64))
 	path = filepath.Base(path)
 	for {
 		h, err := r.Next()
@@ -147,6 +172,114 @@ func (jenga *tarJenga) Read(path string, w io.Writer) (int64, error) {
 	}
 }
 
+func (jenga *tarJenga) OpenReader(path string) (io.ReadCloser, error) {
+	if !jenga.flag.CanRead() {
+		return nil, jengaerr.ReadFlagError
+	}
+	f, err := os.Open(jenga.path)
+	if err != nil {
+		return nil, err
+	}
+	r := tar.NewReader(f)
+	path = filepath.Base(path)
+	for {
+		h, err := r.Next()
+		if err != nil {
+			_ = f.Close()
+			if errors.Is(err, io.EOF) {
+				return nil, jengaerr.TarReadFileNotFoundError.Format(path)
+			} else {
+				return nil, err
+			}
+		}
+		if h.Name == path {
+			return &struct {
+				io.Reader
+				io.Closer
+			}{r, f}, nil
+		}
+	}
+}
+
+func (jenga *tarJenga) Stat(path string) (EntryInfo, error) {
+	f, err := os.Open(jenga.path)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer f.Close()
+	r := tar.NewReader(f)
+	path = filepath.Base(path)
+	for {
+		h, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return EntryInfo{}, jengaerr.TarReadFileNotFoundError.Format(path)
+			} else {
+				return EntryInfo{}, err
+			}
+		}
+		if h.Name == path {
+			// tar.Reader不缓存数据，Next之后文件位置即为数据起始位置
+			offset, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				offset = jengablk.BlkHeaderUnknownOffset
+			}
+			return EntryInfo{
+				Key:        path,
+				Size:       h.Size,
+				OriginSize: h.Size,
+				Offset:     offset,
+				Compress:   compressor.TypeNone,
+				EntryMeta:  getTarMeta(h),
+			}, nil
+		}
+	}
+}
+
+func (jenga *tarJenga) Exists(path string) bool {
+	_, err := jenga.Stat(path)
+	return err == nil
+}
+
+func setTarMeta(hdr *tar.Header, meta *EntryMeta) {
+	hdr.Mode = int64(meta.Mode.Perm())
+	if !meta.ModTime.IsZero() {
+		hdr.ModTime = meta.ModTime
+	}
+	hdr.Uid = meta.Uid
+	hdr.Gid = meta.Gid
+	if len(meta.Attrs) > 0 {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		for k, v := range meta.Attrs {
+			hdr.PAXRecords[tarAttrPrefix+k] = v
+		}
+	}
+}
+
+func getTarMeta(hdr *tar.Header) EntryMeta {
+	ret := EntryMeta{
+		Mode:    hdr.FileInfo().Mode(),
+		ModTime: hdr.ModTime,
+		Uid:     hdr.Uid,
+		Gid:     hdr.Gid,
+	}
+	for k, v := range hdr.PAXRecords {
+		if strings.HasPrefix(k, tarAttrPrefix) {
+			if ret.Attrs == nil {
+				ret.Attrs = map[string]string{}
+			}
+			ret.Attrs[k[len(tarAttrPrefix):]] = v
+		}
+	}
+	return ret
+}
+
+func (jenga *tarJenga) OpenSection(path string) (*io.SectionReader, error) {
+	return nil, jengaerr.NotSupportError.Format("Tar", "OpenSection")
+}
+
 func (jenga *tarJenga) Close() (err error) {
 	if jenga.w != nil {
 		e := jenga.w.Close()
@@ -6,17 +6,26 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/xfali/jenga"
 	"github.com/xfali/jenga/blk"
 	"github.com/xfali/jenga/compressor"
+	"github.com/xfali/jenga/jengaerr"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBlkFileV1(t *testing.T) {
+	cleanFile(t, "./test.blk")
 	t.Run("write", func(t *testing.T) {
 		f := jengablk.NewBlkFile("./test.blk")
 		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
@@ -60,6 +69,7 @@ func TestBlkFileV1(t *testing.T) {
 }
 
 func TestBlkFileV2(t *testing.T) {
+	cleanFile(t, "./test.blk")
 	t.Run("write", func(t *testing.T) {
 		f := jengablk.NewBlkFileV2("./test.blk")
 		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
@@ -103,6 +113,7 @@ func TestBlkFileV2(t *testing.T) {
 }
 
 func TestV1BlockFile(t *testing.T) {
+	cleanFile(t, "./test.blk")
 	t.Run("write1", func(t *testing.T) {
 		f := jengablk.NewV1BlockFile("./test.blk")
 		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
@@ -169,6 +180,7 @@ func TestV1BlockFile(t *testing.T) {
 }
 
 func TestV2BlockFile(t *testing.T) {
+	cleanFile(t, "./test.blk")
 	_ = compressor.NewGzipCompressor()
 	f := jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.WithZlib())
 	t.Run("write1", func(t *testing.T) {
@@ -235,4 +247,1349 @@ func TestV2BlockFile(t *testing.T) {
 			t.Log(buf)
 		}
 	})
+
+	t.Run("size without origin", func(t *testing.T) {
+		err := f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		// 不包含FeatureOriginSize且不读取数据时大小为0，Stat返回BlkHeaderUnknownSize
+		h, err := f.ReadBlock(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Size != 0 {
+			t.Fatal("expect size 0 but get ", h.Size)
+		}
+		for _, v := range f.Keys() {
+			n, err := f.ReadBlockByKey(v, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 {
+				t.Fatal("expect size 0 but get ", n)
+			}
+			info, err := f.StatBlock(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.OriginSize != jengablk.BlkHeaderUnknownSize {
+				t.Fatal("expect unknown size but get ", info.OriginSize)
+			}
+		}
+	})
+}
+
+func TestV3BlockFile(t *testing.T) {
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip())
+	write := func(t *testing.T, path string) {
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = f.WriteFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, expect ...string) {
+		err := f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		keys := f.Keys()
+		if len(keys) != len(expect) {
+			t.Fatalf("expect %d keys but get %v", len(expect), keys)
+		}
+		for _, v := range expect {
+			buf := &strings.Builder{}
+			_, err := f.ReadBlockByKey(v, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, _ := ioutil.ReadFile(v)
+			if buf.String() != string(d) {
+				t.Fatal("data not match: ", v)
+			}
+		}
+	}
+
+	t.Run("write1", func(t *testing.T) {
+		write(t, "./test.json")
+	})
+	t.Run("write2", func(t *testing.T) {
+		write(t, "./test2.json")
+	})
+	t.Run("read", func(t *testing.T) {
+		read(t, "./test.json", "./test2.json")
+	})
+	t.Run("without footer", func(t *testing.T) {
+		d, err := ioutil.ReadFile("./test.blk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 模拟Close之前进程退出：移除尾部索引及footer
+		offset := binary.BigEndian.Uint64(d[len(d)-jengablk.BlkFileV3FooterSize:])
+		err = os.Truncate("./test.blk", int64(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read(t, "./test.json", "./test2.json")
+		f := jengablk.NewBlkFileV3("./test.blk")
+		err = f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		count := 0
+		for {
+			_, err := f.ReadBlock(ioutil.Discard)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				t.Fatal(err)
+			}
+			count++
+		}
+		if count != 2 {
+			t.Fatal("expect 2 blocks but get ", count)
+		}
+	})
+	t.Run("rebuild", func(t *testing.T) {
+		f := jengablk.NewV3BlockFile("./test.blk")
+		err := f.Open(jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteBlock("rebuild", strings.NewReader("hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		err = f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if len(f.Keys()) != 3 {
+			t.Fatal("expect 3 keys but get ", f.Keys())
+		}
+		buf := &strings.Builder{}
+		_, err = f.ReadBlockByKey("rebuild", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "hello world" {
+			t.Fatal("expect hello world but get ", buf.String())
+		}
+	})
+	t.Run("broken index", func(t *testing.T) {
+		d, err := ioutil.ReadFile("./test.blk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := binary.BigEndian.Uint64(d[len(d)-jengablk.BlkFileV3FooterSize:])
+		// 索引的实体个数超出索引长度
+		vi := jengablk.VarInt{}
+		vi.InitFromUInt64(1 << 62)
+		index := append(vi.Bytes(), 1, 'k')
+		vi.InitFromUInt64(uint64(len(index)))
+		magic := make([]byte, jengablk.BlkFileV3TrailerMagicSize)
+		binary.BigEndian.PutUint32(magic, jengablk.BlkFileV3TrailerMagic)
+		trailer := append(append(append(magic, 1), vi.Bytes()...), index...)
+		footer := make([]byte, jengablk.BlkFileV3FooterSize)
+		binary.BigEndian.PutUint64(footer, offset)
+		binary.BigEndian.PutUint32(footer[8:], crc32.ChecksumIEEE(trailer))
+		binary.BigEndian.PutUint32(footer[12:], jengablk.BlkFileV3FooterMagic)
+		for _, v := range [][]byte{append(trailer, footer...), trailer} {
+			err = ioutil.WriteFile("./test.blk", append(append([]byte{}, d[:offset]...), v...), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 尾部索引损坏（footer存在）或不是索引（footer缺失）时全量扫描
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := f.Keys()
+			_ = f.Close()
+			if len(keys) != 3 {
+				t.Fatal("expect 3 keys but get ", keys)
+			}
+		}
+	})
+}
+
+func TestBlockChecksum(t *testing.T) {
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithChecksum())
+	t.Run("write", func(t *testing.T) {
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = f.WriteBlock("hello", strings.NewReader("hello world"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		err := f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		buf := &strings.Builder{}
+		_, err = f.ReadBlockByKey("hello", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "hello world" {
+			t.Fatal("expect hello world but get ", buf.String())
+		}
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		d, err := ioutil.ReadFile("./test.blk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := strings.Index(string(d), "hello world")
+		d[i] ^= 0x01
+		err = ioutil.WriteFile("./test.blk", d, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = f.ReadBlockByKey("hello", ioutil.Discard)
+		if !jengaerr.ReadChecksumNotMatchError.Equal(err) {
+			t.Fatal("expect checksum error but get ", err)
+		}
+		bf := jengablk.NewBlkFileV3("./test.blk")
+		err = bf.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bf.Close()
+		_, err = bf.ReadBlock(ioutil.Discard)
+		if !jengaerr.ReadChecksumNotMatchError.Equal(err) {
+			t.Fatal("expect checksum error but get ", err)
+		}
+	})
+}
+
+func TestBlockFeatureVersion(t *testing.T) {
+	write := func(t *testing.T, opts ...jengablk.BlocksV2Opt) []byte {
+		cleanFile(t, "./test.blk")
+		f := jengablk.NewV2BlockFile("./test.blk", opts...)
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteBlock("hello", strings.NewReader("hello world"))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := ioutil.ReadFile("./test.blk")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	read := func(t *testing.T, d []byte) error {
+		err := ioutil.WriteFile("./test.blk", d, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []jengablk.JengaBlocks{jengablk.NewV2BlockFile("./test.blk"), jengablk.NewV3BlockFile("./test.blk")} {
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				return err
+			}
+			buf := &strings.Builder{}
+			_, err = f.ReadBlockByKey("hello", buf)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			if buf.String() != "hello world" {
+				t.Fatal("expect hello world but get ", buf.String())
+			}
+		}
+		return nil
+	}
+
+	t.Run("version", func(t *testing.T) {
+		// 不包含格式特性的V2文件与旧版本相同
+		d := write(t)
+		if v := binary.BigEndian.Uint16(d[4:]); v != jengablk.BlkFileV2Version {
+			t.Fatal("expect version 2 but get ", v)
+		}
+		// 包含格式特性时旧版本程序无法读取
+		d = write(t, jengablk.BlockV2Opts.WithChecksum())
+		if v := binary.BigEndian.Uint16(d[4:]); v != jengablk.BlkFileV2FeatureVersion {
+			t.Fatal("expect feature version but get ", v)
+		}
+		if err := read(t, d); err != nil {
+			t.Fatal(err)
+		}
+		// 兼容此前以版本号2写入的包含格式特性的文件
+		binary.BigEndian.PutUint16(d[4:], jengablk.BlkFileV2Version)
+		if err := read(t, d); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unknown feature", func(t *testing.T) {
+		d := write(t, jengablk.BlockV2Opts.WithChecksum())
+		binary.BigEndian.PutUint16(d[8:], binary.BigEndian.Uint16(d[8:])|1<<15)
+		err := read(t, d)
+		if !jengaerr.FeatureNotSupportError.Equal(err) {
+			t.Fatal("expect feature not support error but get ", err)
+		}
+		s := jenga.NewScanner(bytes.NewReader(d))
+		if s.Next() || !jengaerr.FeatureNotSupportError.Equal(s.Err()) {
+			t.Fatal("expect feature not support error but get ", s.Err())
+		}
+	})
+}
+
+func TestBlockTombstone(t *testing.T) {
+	newBlocks := map[string]func() jengablk.JengaBlocks{
+		"v2": func() jengablk.JengaBlocks {
+			return jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.AllowOverwrite())
+		},
+		"v3": func() jengablk.JengaBlocks {
+			return jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.AllowOverwrite())
+		},
+	}
+	for name, newBlock := range newBlocks {
+		t.Run(name, func(t *testing.T) {
+			cleanFile(t, "./test.blk")
+			f := newBlock()
+			err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = f.WriteBlock("a", strings.NewReader("a1"))
+			_, _ = f.WriteBlock("b", strings.NewReader("b1"))
+			f.Close()
+
+			f = newBlock()
+			err = f.Open(jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteBlock("a", strings.NewReader("a2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.DeleteBlock("b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.DeleteBlock("c")
+			if !jengaerr.ReadKeyNotFoundError.Equal(err) {
+				t.Fatal("expect not found error but get ", err)
+			}
+			f.Close()
+
+			f = newBlock()
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			keys := f.Keys()
+			if len(keys) != 1 || keys[0] != "a" {
+				t.Fatal("expect [a] but get ", keys)
+			}
+			buf := &strings.Builder{}
+			_, err = f.ReadBlockByKey("a", buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "a2" {
+				t.Fatal("expect a2 but get ", buf.String())
+			}
+			_, err = f.ReadBlockByKey("b", buf)
+			if !jengaerr.ReadKeyNotFoundError.Equal(err) {
+				t.Fatal("expect not found error but get ", err)
+			}
+		})
+	}
+}
+
+func TestBlockCompact(t *testing.T) {
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.AllowOverwrite())
+	err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteBlock("a", strings.NewReader(strings.Repeat("a1", 100)))
+	_, _ = f.WriteBlock("b", strings.NewReader(strings.Repeat("b1", 100)))
+	_, _ = f.WriteBlock("c", strings.NewReader(strings.Repeat("c1", 100)))
+	_, _ = f.WriteBlock("a", strings.NewReader(strings.Repeat("a2", 100)))
+	_ = f.DeleteBlock("b")
+	f.Close()
+
+	compact := func(t *testing.T, dst jengablk.JengaBlocks) {
+		err := f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = dst.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Compact(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dst.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = dst.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+		if len(dst.Keys()) != 2 {
+			t.Fatal("expect 2 keys but get ", dst.Keys())
+		}
+		for k, v := range map[string]string{"a": "a2", "c": "c1"} {
+			buf := &strings.Builder{}
+			_, err = dst.ReadBlockByKey(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != strings.Repeat(v, 100) {
+				t.Fatal("data not match, key: ", k)
+			}
+		}
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		cleanFile(t, "./test_compact.blk")
+		compact(t, jengablk.NewV3BlockFile("./test_compact.blk", jengablk.BlockV2Opts.WithGzip()))
+		src, _ := os.Stat("./test.blk")
+		dst, _ := os.Stat("./test_compact.blk")
+		if dst.Size() >= src.Size() {
+			t.Fatal("compact file is not smaller than source")
+		}
+	})
+
+	t.Run("recompress", func(t *testing.T) {
+		cleanFile(t, "./test_compact.blk")
+		compact(t, jengablk.NewV2BlockFile("./test_compact.blk", jengablk.BlockV2Opts.WithChecksum()))
+	})
+
+	t.Run("without origin size", func(t *testing.T) {
+		// 源文件未记录原始大小，直接复制压缩数据时需获得原始大小
+		cleanFile(t, "./test.blk")
+		f = jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.AllowOverwrite())
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteBlock("a", strings.NewReader(strings.Repeat("a2", 100)))
+		_, _ = f.WriteBlock("c", strings.NewReader(strings.Repeat("c1", 100)))
+		f.Close()
+
+		cleanFile(t, "./test_compact.blk")
+		compact(t, jengablk.NewV3BlockFile("./test_compact.blk", jengablk.BlockV2Opts.WithGzip()))
+		b := jengablk.NewV3BlockFile("./test_compact.blk")
+		err = b.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "c"} {
+			info, err := b.StatBlock(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.OriginSize != 200 {
+				t.Fatal("expect origin size 200 but get ", info.OriginSize)
+			}
+		}
+		b.Close()
+		// 实体头中的原始大小
+		dst := jengablk.NewBlkFileV3("./test_compact.blk")
+		err = dst.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+		for {
+			h, err := dst.ReadBlock(nil)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				t.Fatal(err)
+			}
+			if h.Size != 200 {
+				t.Fatal("expect origin size 200 but get ", h.Size)
+			}
+		}
+	})
+}
+
+func TestBlockReadWrite(t *testing.T) {
+	newBlocks := map[string]func() jengablk.JengaBlocks{
+		"v2": func() jengablk.JengaBlocks {
+			return jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.AllowOverwrite())
+		},
+		"v3": func() jengablk.JengaBlocks {
+			return jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.AllowOverwrite())
+		},
+	}
+	readKey := func(t *testing.T, f jengablk.JengaBlocks, key, expect string) {
+		buf := &strings.Builder{}
+		_, err := f.ReadBlockByKey(key, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expect {
+			t.Fatalf("key %s expect %s but get %s", key, expect, buf.String())
+		}
+	}
+	for name, newBlock := range newBlocks {
+		t.Run(name, func(t *testing.T) {
+			cleanFile(t, "./test.blk")
+			f := newBlock()
+			err := f.Open(jenga.OpFlagReadWrite | jenga.OpFlagCreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteBlock("a", strings.NewReader("a1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			readKey(t, f, "a", "a1")
+			_, err = f.WriteBlock("b", strings.NewReader("b1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			readKey(t, f, "a", "a1")
+			readKey(t, f, "b", "b1")
+			if len(f.Keys()) != 2 {
+				t.Fatal("expect 2 keys but get ", f.Keys())
+			}
+			_, err = f.ReadBlock(nil)
+			if !jengaerr.NotSupportError.Equal(err) {
+				t.Fatal("expect not support error but get ", err)
+			}
+			f.Close()
+
+			before, err := ioutil.ReadFile("./test.blk")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f = newBlock()
+			err = f.Open(jenga.OpFlagReadWrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readKey(t, f, "b", "b1")
+			// 追加写入之前保留尾部索引，文件不变
+			after, err := ioutil.ReadFile("./test.blk")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Fatal("expect file not changed before writing")
+			}
+			_, err = f.WriteBlock("a", strings.NewReader("a2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			readKey(t, f, "a", "a2")
+			_, err = f.WriteBlock("c", strings.NewReader("c1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			readKey(t, f, "c", "c1")
+			f.Close()
+
+			// 未写入数据时Close重新写入尾部索引
+			f = newBlock()
+			err = f.Open(jenga.OpFlagReadWrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			f = newBlock()
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if len(f.Keys()) != 3 {
+				t.Fatal("expect 3 keys but get ", f.Keys())
+			}
+			readKey(t, f, "a", "a2")
+			readKey(t, f, "b", "b1")
+			readKey(t, f, "c", "c1")
+		})
+	}
+}
+
+func TestBlockMeta(t *testing.T) {
+	meta := jengablk.EntryMeta{
+		Mode:    0640,
+		ModTime: time.Unix(1600000000, 123),
+		Uid:     1000,
+		Gid:     -1,
+		Attrs: map[string]string{
+			"content-type": "text/plain",
+			"empty":        "",
+		},
+	}
+	checkMeta := func(t *testing.T, f jengablk.JengaBlocks, key string, expect jengablk.EntryMeta) {
+		info, err := f.StatBlock(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Key != key || info.Mode != expect.Mode || !info.ModTime.Equal(expect.ModTime) ||
+			info.Uid != expect.Uid || info.Gid != expect.Gid || len(info.Attrs) != len(expect.Attrs) {
+			t.Fatalf("key %s meta not match: %v", key, info)
+		}
+		for k, v := range expect.Attrs {
+			if info.Attrs[k] != v {
+				t.Fatalf("key %s attr %s not match: %s", key, k, info.Attrs[k])
+			}
+		}
+	}
+	newBlocks := map[string]func(path string) jengablk.JengaBlocks{
+		"v2": func(path string) jengablk.JengaBlocks {
+			return jengablk.NewV2BlockFile(path, jengablk.BlockV2Opts.WithMeta(), jengablk.BlockV2Opts.WithChecksum())
+		},
+		"v3": func(path string) jengablk.JengaBlocks {
+			return jengablk.NewV3BlockFile(path, jengablk.BlockV2Opts.WithMeta(), jengablk.BlockV2Opts.WithGzip())
+		},
+	}
+	for name, newBlock := range newBlocks {
+		t.Run(name, func(t *testing.T) {
+			cleanFile(t, "./test.blk")
+			cleanFile(t, "./test_compact.blk")
+			f := newBlock("./test.blk")
+			err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteBlockWithMeta("a", meta, strings.NewReader("with meta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteBlock("b", strings.NewReader("without meta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			f = newBlock("./test.blk")
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			checkMeta(t, f, "a", meta)
+			checkMeta(t, f, "b", jengablk.EntryMeta{})
+			buf := &strings.Builder{}
+			_, err = f.ReadBlockByKey("a", buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != "with meta" {
+				t.Fatal("expect with meta but get ", buf.String())
+			}
+			_, err = f.StatBlock("c")
+			if !jengaerr.ReadKeyNotFoundError.Equal(err) {
+				t.Fatal("expect not found error but get ", err)
+			}
+
+			dst := newBlock("./test_compact.blk")
+			err = dst.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Compact(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst.Close()
+			err = dst.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+			checkMeta(t, dst, "a", meta)
+			checkMeta(t, dst, "b", jengablk.EntryMeta{})
+		})
+	}
+
+	t.Run("not support", func(t *testing.T) {
+		cleanFile(t, "./test.blk")
+		f := jengablk.NewV3BlockFile("./test.blk")
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = f.WriteBlockWithMeta("a", meta, strings.NewReader("a"))
+		if !jengaerr.NotSupportError.Equal(err) {
+			t.Fatal("expect not support error but get ", err)
+		}
+	})
+}
+
+func TestBlockStream(t *testing.T) {
+	data := map[string]string{
+		"a": strings.Repeat("stream a|", 10000),
+		"b": "stream b",
+		"c": "",
+	}
+	readAll := func(t *testing.T, f jengablk.JengaBlocks, expect map[string]string) {
+		if len(f.Keys()) != len(expect) {
+			t.Fatal("expect keys ", len(expect), " but get ", f.Keys())
+		}
+		for k, v := range expect {
+			buf := &strings.Builder{}
+			_, err := f.ReadBlockByKey(k, buf)
+			if err != nil {
+				t.Fatal(k, err)
+			}
+			if buf.String() != v {
+				t.Fatalf("key %s data not match", k)
+			}
+			r, err := f.OpenBlock(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := ioutil.ReadAll(r)
+			_ = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(d) != v {
+				t.Fatalf("key %s data not match", k)
+			}
+		}
+	}
+	for name, opts := range map[string][]jengablk.BlocksV2Opt{
+		"none":     nil,
+		"gzip":     {jengablk.BlockV2Opts.WithGzip()},
+		"checksum": {jengablk.BlockV2Opts.WithZlib(), jengablk.BlockV2Opts.WithChecksum(), jengablk.BlockV2Opts.WithMeta()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cleanFile(t, "./test.blk")
+			// bytes.Buffer不支持Seek
+			buf := &bytes.Buffer{}
+			f := jengablk.NewV3Blocks(append([]jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithWriter(buf)}, opts...)...)
+			err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{"a", "b", "c"} {
+				_, err = f.WriteBlock(k, strings.NewReader(data[k]))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+			if !jengaerr.NotSupportError.Equal(err) {
+				t.Fatal("expect not support error but get ", err)
+			}
+			err = ioutil.WriteFile("./test.blk", buf.Bytes(), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f = jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.AllowOverwrite())
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readAll(t, f, data)
+			_, err = f.OpenBlockSection("b")
+			if !jengaerr.NotSupportError.Equal(err) {
+				t.Fatal("expect not support error but get ", err)
+			}
+			f.Close()
+
+			// 分块格式的文件可继续追加、覆盖及删除
+			err = f.Open(jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.WriteBlock("b", strings.NewReader("stream b2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.DeleteBlock("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			// 移除尾部索引，全量扫描
+			info, err := os.Stat("./test.blk")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Truncate("./test.blk", info.Size()-jengablk.BlkFileV3FooterSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f = jengablk.NewV3BlockFile("./test.blk")
+			err = f.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			readAll(t, f, map[string]string{
+				"a": data["a"],
+				"b": "stream b2",
+			})
+		})
+	}
+}
+
+const typeXor = 0x100
+
+// 测试用压缩器：按字节异或
+type xorCompressor struct{}
+
+func (c xorCompressor) Type() compressor.Type {
+	return typeXor
+}
+
+func (c xorCompressor) Compress(dstWriter io.Writer, srcReader io.Reader) (int64, int64, error) {
+	return c.copy(dstWriter, srcReader)
+}
+
+func (c xorCompressor) Decompress(dstWriter io.Writer, srcReader io.Reader) (int64, int64, error) {
+	return c.copy(dstWriter, srcReader)
+}
+
+func (c xorCompressor) copy(w io.Writer, r io.Reader) (int64, int64, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range d {
+		d[i] ^= 0x5A
+	}
+	n, err := w.Write(d)
+	return int64(n), int64(n), err
+}
+
+func TestBlockEntryCompress(t *testing.T) {
+	data := map[string]string{
+		"a.txt": strings.Repeat("entry a|", 1000),
+		"b.JPG": strings.Repeat("entry b|", 1000),
+		"c.txt": strings.Repeat("entry c|", 1000),
+	}
+	expect := map[string]compressor.Type{
+		"a.txt": compressor.TypeGzip,
+		"b.JPG": compressor.TypeNone,
+		"c.txt": compressor.TypeZstd,
+	}
+	check := func(t *testing.T, f jengablk.JengaBlocks) {
+		for k, v := range data {
+			buf := &strings.Builder{}
+			_, err := f.ReadBlockByKey(k, buf)
+			if err != nil {
+				t.Fatal(k, err)
+			}
+			if buf.String() != v {
+				t.Fatalf("key %s data not match", k)
+			}
+			info, err := f.StatBlock(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Compress != expect[k] {
+				t.Fatalf("key %s compress type not match: %d", k, info.Compress)
+			}
+		}
+	}
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(),
+		jengablk.BlockV2Opts.NoCompressExt(".jpg"), jengablk.BlockV2Opts.WithChecksum())
+	err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a.txt", "b.JPG"} {
+		_, err = f.WriteBlock(k, strings.NewReader(data[k]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	// 追加写入时使用与文件不同的压缩器
+	f = jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithZstd())
+	err = f.Open(jenga.OpFlagWriteOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteBlock("c.txt", strings.NewReader(data["c.txt"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f = jengablk.NewV3BlockFile("./test.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, f)
+	if f.Compressor().Type() != compressor.TypeGzip {
+		t.Fatal("expect gzip but get ", f.Compressor().Type())
+	}
+	_, err = f.OpenBlockSection("b.JPG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.OpenBlockSection("a.txt")
+	if !jengaerr.NotSupportError.Equal(err) {
+		t.Fatal("expect not support error but get ", err)
+	}
+
+	// 压缩空间时保留各实体的压缩算法
+	cleanFile(t, "./test_compact.blk")
+	dst := jengablk.NewV3BlockFile("./test_compact.blk", jengablk.BlockV2Opts.WithChecksum())
+	err = dst.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Compact(dst)
+	f.Close()
+	dst.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst = jengablk.NewV3BlockFile("./test_compact.blk")
+	err = dst.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, dst)
+	dst.Close()
+
+	d, err := ioutil.ReadFile("./test.blk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := jengablk.NewScanner(bytes.NewReader(d))
+	count := 0
+	for s.Next() {
+		buf := &strings.Builder{}
+		_, err = s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != data[s.Entry().Key] || s.Entry().Compress != expect[s.Entry().Key] {
+			t.Fatalf("key %s not match", s.Entry().Key)
+		}
+		count++
+	}
+	if s.Err() != nil || count != len(data) {
+		t.Fatal("scan failed: ", count, s.Err())
+	}
+
+	// 移除footer，全量扫描
+	err = ioutil.WriteFile("./test.blk", d[:len(d)-jengablk.BlkFileV3FooterSize], 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = jengablk.NewV3BlockFile("./test.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, f)
+	f.Close()
+
+	// 不包含实体压缩类型的文件不能使用其他压缩器写入
+	cleanFile(t, "./test.blk")
+	f = jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.NoCompressExt(".jpg"))
+	err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteBlock("b.JPG", strings.NewReader(data["b.JPG"]))
+	if !jengaerr.NotSupportError.Equal(err) {
+		t.Fatal("expect not support error but get ", err)
+	}
+	f.Close()
+	f = jengablk.NewV2BlockFile("./test.blk", jengablk.BlockV2Opts.WithZlib())
+	err = f.Open(jenga.OpFlagWriteOnly)
+	if !jengaerr.NotSupportError.Equal(err) {
+		t.Fatal("expect not support error but get ", err)
+	}
+	// 只读打开时使用文件记录的压缩类型
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestBlockAdaptive(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	data := map[string]string{
+		"a.txt": strings.Repeat("adaptive a|", 10000),
+		"b.bin": string(random),
+	}
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithAdaptive(compressor.NewGzipCompressor()))
+	err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a.txt", "b.bin"} {
+		_, err = f.WriteBlock(k, strings.NewReader(data[k]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := f.Compressor().(compressor.AdaptiveStatsCompressor).Stats()
+	f.Close()
+	if stats.Compressed != 1 || stats.Raw != 1 || stats.RawSize != int64(len(random)) {
+		t.Fatal("stats not match: ", stats)
+	}
+
+	f = jengablk.NewV3BlockFile("./test.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for k, v := range data {
+		buf := &strings.Builder{}
+		_, err = f.ReadBlockByKey(k, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != v {
+			t.Fatalf("key %s data not match", k)
+		}
+	}
+	info, err := f.StatBlock("b.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(random))+1 || info.Compress != compressor.TypeAdaptive {
+		t.Fatal("raw size not match: ", info.Size)
+	}
+}
+
+func TestBlockCompressorRegistry(t *testing.T) {
+	data := map[string]string{
+		"a": strings.Repeat("registry a|", 1000),
+		"b": "registry b",
+	}
+	cleanFile(t, "./test.blk")
+	f := jengablk.NewV3BlockFile("./test.blk", jengablk.BlockV2Opts.WithCompressor(xorCompressor{}))
+	err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range data {
+		_, err = f.WriteBlock(k, strings.NewReader(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	f = jengablk.NewV3BlockFile("./test.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if !jengaerr.DataFormatNotSupportError.Equal(err) {
+		t.Fatal("expect data format not support error but get ", err)
+	}
+
+	compressor.Register(typeXor, "xor", func() compressor.Compressor {
+		return xorCompressor{}
+	})
+	f = jengablk.NewV3BlockFile("./test.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for k, v := range data {
+		buf := &strings.Builder{}
+		_, err = f.ReadBlockByKey(k, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != v {
+			t.Fatalf("key %s data not match", k)
+		}
+		info, err := f.StatBlock(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Compress != typeXor {
+			t.Fatal("compress type not match: ", info.Compress)
+		}
+	}
+}
+
+func TestBlockBzip2(t *testing.T) {
+	d, err := ioutil.ReadFile("./test.json.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadFile("./test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanFile(t, "./test_bzip2.blk")
+	// 直接写入其他工具生成的bzip2数据
+	f := jengablk.NewV3BlockFile("./test_bzip2.blk", jengablk.BlockV2Opts.WithChecksum())
+	err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.WriteRawBlock("test.json", compressor.TypeBzip2, bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(expect)) {
+		t.Fatal("size not match: ", n)
+	}
+	_, err = f.WriteRawBlock("broken.json", compressor.TypeBzip2, bytes.NewReader(expect))
+	if err == nil {
+		t.Fatal("expect decompress error")
+	}
+	// 写入失败的key可重新写入
+	for _, k := range f.Keys() {
+		if k == "broken.json" {
+			t.Fatal("expect broken.json not exists")
+		}
+	}
+	_, err = f.WriteRawBlock("broken.json", compressor.TypeBzip2, bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f = jengablk.NewV3BlockFile("./test_bzip2.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.StatBlock("test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Compress != compressor.TypeBzip2 || info.OriginSize != int64(len(expect)) || info.Size != int64(len(d)) {
+		t.Fatal("info not match: ", info)
+	}
+	buf := &strings.Builder{}
+	_, err = f.ReadBlockByKey("test.json", buf)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(expect) {
+		t.Fatal("data not match: ", buf.String())
+	}
+
+	// 分块格式同样原样写入
+	cleanFile(t, "./test_bzip2_stream.blk")
+	f = jengablk.NewV3BlockFile("./test_bzip2_stream.blk", jengablk.BlockV2Opts.WithStream(), jengablk.BlockV2Opts.WithChecksum())
+	err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteRawBlock("test.json", compressor.TypeBzip2, bytes.NewReader(d))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = jengablk.NewV3BlockFile("./test_bzip2_stream.blk")
+	err = f.Open(jenga.OpFlagReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	_, err = f.ReadBlockByKey("test.json", buf)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(expect) {
+		t.Fatal("data not match: ", buf.String())
+	}
+
+	// 不包含entry-compress的文件只能写入与文件相同压缩类型的数据
+	cleanFile(t, "./test_bzip2_v2.blk")
+	f = jengablk.NewV2BlockFile("./test_bzip2_v2.blk")
+	err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteRawBlock("test.json", compressor.TypeBzip2, bytes.NewReader(d))
+	f.Close()
+	if !jengaerr.NotSupportError.Equal(err) {
+		t.Fatal("expect not support error but get ", err)
+	}
+
+	// 只读压缩器不能写入
+	f = jengablk.NewV3BlockFile("./test_bzip2.blk", jengablk.BlockV2Opts.WithCompressor(compressor.NewBzip2Compressor()))
+	err = f.Open(jenga.OpFlagWriteOnly)
+	if !jengaerr.CompressorReadOnlyError.Equal(err) {
+		t.Fatal("expect read only error but get ", err)
+	}
+	cleanFile(t, "./test_bzip2_new.blk")
+	f = jengablk.NewV3BlockFile("./test_bzip2_new.blk", jengablk.BlockV2Opts.WithCompressor(compressor.NewBzip2Compressor()))
+	err = f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+	if !jengaerr.CompressorReadOnlyError.Equal(err) {
+		t.Fatal("expect read only error but get ", err)
+	}
+}
+
+func TestBlockZstdDict(t *testing.T) {
+	dict, err := ioutil.ReadFile("./zstd.dict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{}
+	for i := 0; i < 20; i++ {
+		data[fmt.Sprintf("%d.json", i)] = fmt.Sprintf(`{"id":%d,"name":"user%d","level":"info","message":"request finished","tags":["api","db","http"],"latency_ms":%d}`, i, i*7, i*13)
+	}
+	write := func(t *testing.T, path string, opts ...compressor.ZstdOpt) int64 {
+		cleanFile(t, path)
+		f := jengablk.NewV3BlockFile(path, jengablk.BlockV2Opts.WithZstd(opts...))
+		err := f.Open(jenga.OpFlagWriteOnly | jenga.OpFlagCreate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var size int64
+		for k, v := range data {
+			_, err = f.WriteBlock(k, strings.NewReader(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := f.StatBlock(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += info.Size
+		}
+		f.Close()
+		return size
+	}
+	read := func(t *testing.T, path string) {
+		// 读取时使用文件中保存的字典
+		f := jengablk.NewV3BlockFile(path)
+		err := f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for k, v := range data {
+			buf := &strings.Builder{}
+			_, err = f.ReadBlockByKey(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != v {
+				t.Fatalf("key %s data not match", k)
+			}
+		}
+	}
+	level := compressor.ZstdOpts.Level(compressor.ZstdSpeedBetterCompression)
+	plain := write(t, "./test_zstd.blk", level)
+	read(t, "./test_zstd.blk")
+	withDict := write(t, "./test_zstd_dict.blk", compressor.ZstdOpts.Dict(dict), level)
+	read(t, "./test_zstd_dict.blk")
+	t.Log("zstd: ", plain, " zstd with dict: ", withDict)
+	if withDict >= plain {
+		t.Fatal("expect smaller size with dictionary")
+	}
+
+	h, err := readFileHeader("./test_zstd_dict.blk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.DataFormat != compressor.TypeZstd || !h.HasFeature(jengablk.FeatureDict) {
+		t.Fatal("header not match: ", h)
+	}
+
+	// 追加写入时使用文件中的字典
+	f := jengablk.NewV3BlockFile("./test_zstd_dict.blk", jengablk.BlockV2Opts.WithZstd())
+	err = f.Open(jenga.OpFlagWriteOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data["append.json"] = `{"id":100,"name":"append","level":"warn","message":"cache miss"}`
+	_, err = f.WriteBlock("append.json", strings.NewReader(data["append.json"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	read(t, "./test_zstd_dict.blk")
+
+	d, err := ioutil.ReadFile("./test_zstd_dict.blk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := jengablk.NewScanner(bytes.NewReader(d))
+	count := 0
+	for s.Next() {
+		buf := &strings.Builder{}
+		_, err = s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != data[s.Entry().Key] {
+			t.Fatalf("key %s data not match", s.Entry().Key)
+		}
+		count++
+	}
+	if s.Err() != nil || count != len(data) {
+		t.Fatal("scan failed: ", count, s.Err())
+	}
+}
+
+func readFileHeader(path string) (jengablk.FileHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return jengablk.FileHeader{}, err
+	}
+	defer f.Close()
+	return jengablk.ReadFileHeader(f)
 }
@@ -0,0 +1,115 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"errors"
+	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestJengaFS(t *testing.T) {
+	files := map[string]string{
+		"index.html":        "<html>index</html>",
+		"css/main.css":      "body {}",
+		"js/lib/jquery.js":  strings.Repeat("jquery", 1000),
+		"js/app.js":         "console.log('app')",
+		"/abs/leading.txt":  "leading slash",
+		"./dot/current.txt": "current dir",
+	}
+	for name, opts := range map[string][]jengablk.BlocksV2Opt{
+		"none": nil,
+		"gzip": {jengablk.BlockV2Opts.WithGzip()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "./test_fs_" + name + ".jenga"
+			cleanFile(t, path)
+			blks := jenga.NewJenga(path, jenga.V3(opts...))
+			err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range files {
+				_, err := blks.Write(k, strings.NewReader(v))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = blks.Close()
+
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer blks.Close()
+			fsys := jenga.FS(blks)
+			err = fstest.TestFS(fsys, "index.html", "css/main.css", "js/lib/jquery.js", "js/app.js",
+				"abs/leading.txt", "dot/current.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := fs.ReadFile(fsys, "js/lib/jquery.js")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != files["js/lib/jquery.js"] {
+				t.Fatal("data not match")
+			}
+			info, err := fs.Stat(fsys, "js/lib/jquery.js")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(files["js/lib/jquery.js"])) {
+				t.Fatal("size not match: ", info.Size())
+			}
+			entries, err := fs.ReadDir(fsys, "js")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[0].Name() != "app.js" || !entries[1].IsDir() {
+				t.Fatal("entries not match: ", entries)
+			}
+
+			var count int
+			err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(files) {
+				t.Fatal("file count not match: ", count)
+			}
+
+			_, err = fsys.Open("not/exists")
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expect not exist, got: ", err)
+			}
+			f, err := fsys.Open("index.html")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			data, err = ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log(string(data))
+		})
+	}
+}
@@ -6,15 +6,34 @@
 package test
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"github.com/xfali/jenga"
 	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/compressor"
 	"github.com/xfali/jenga/jengaerr"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
+func cleanFile(t *testing.T, path string) {
+	_ = os.Remove(path)
+	t.Cleanup(func() {
+		_ = os.Remove(path)
+	})
+}
+
 func TestJengaV1(t *testing.T) {
+	cleanFile(t, "./test.db")
 	blks := jenga.NewJenga("./test.db")
 	t.Run("write", func(t *testing.T) {
 		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
@@ -63,6 +82,7 @@ func TestJengaV1(t *testing.T) {
 }
 
 func TestJengaV2(t *testing.T) {
+	cleanFile(t, "./test.db")
 	blks := jenga.NewJenga("./test.db", jenga.V2(jengablk.BlockV2Opts.WithGzip()))
 		//jengablk.BlockV2Opts.KeyMatch("^asdadsad")))
 	t.Run("write", func(t *testing.T) {
@@ -114,3 +134,1288 @@ func TestJengaV2(t *testing.T) {
 		t.Log(b.String())
 	})
 }
+
+func TestJengaOpenReader(t *testing.T) {
+	data1 := strings.Repeat("hello world ", 10000)
+	data2 := strings.Repeat("jenga ", 10000)
+	write := func(t *testing.T, blks jenga.Jenga) {
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		_, _ = blks.Write("1", strings.NewReader(data1))
+		_, _ = blks.Write("2", strings.NewReader(data2))
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		cleanFile(t, "./test.db")
+		blks := jenga.NewJenga("./test.db", jenga.V3(jengablk.BlockV2Opts.WithGzip()))
+		write(t, blks)
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		r, err := blks.OpenReader("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		buf := make([]byte, 100)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 读取过程中读取其他数据
+		b := &strings.Builder{}
+		_, err = blks.Read("2", b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != data2 {
+			t.Fatal("data 2 not match")
+		}
+		left, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf)+string(left) != data1 {
+			t.Fatal("data 1 not match")
+		}
+		_, err = blks.OpenSection("1")
+		if !jengaerr.NotSupportError.Equal(err) {
+			t.Fatal("expect not support error but get ", err)
+		}
+	})
+
+	t.Run("section", func(t *testing.T) {
+		cleanFile(t, "./test.db")
+		blks := jenga.NewJenga("./test.db", jenga.V3())
+		write(t, blks)
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		r, err := blks.OpenSection("2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(len(data2)) {
+			t.Fatal("size not match")
+		}
+		buf := make([]byte, 5)
+		_, err = r.ReadAt(buf, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "jenga" {
+			t.Fatal("expect jenga but get ", string(buf))
+		}
+		rc, err := blks.OpenReader("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, ok := rc.(io.ReadSeeker); !ok {
+			t.Fatal("reader of uncompressed data must be a io.ReadSeeker")
+		}
+	})
+}
+
+func TestJengaConcurrentRead(t *testing.T) {
+	const (
+		keyCount  = 20
+		routines  = 16
+		readTimes = 100
+	)
+	value := func(i int) string {
+		return strings.Repeat(fmt.Sprintf("value-%d|", i), 100*(i+1))
+	}
+	checkRead := func(t *testing.T, blks jenga.Jenga, i int) {
+		buf := &strings.Builder{}
+		_, err := blks.Read(fmt.Sprintf("key-%d", i), buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if buf.String() != value(i) {
+			t.Errorf("key-%d data not match", i)
+		}
+	}
+	hammer := func(t *testing.T, blks jenga.Jenga) {
+		wait := sync.WaitGroup{}
+		for r := 0; r < routines; r++ {
+			wait.Add(1)
+			go func(r int) {
+				defer wait.Done()
+				for i := 0; i < readTimes; i++ {
+					checkRead(t, blks, (r+i)%keyCount)
+				}
+			}(r)
+		}
+		wait.Wait()
+	}
+	for name, opt := range map[string]jenga.Opt{
+		"v2":   jenga.V2(jengablk.BlockV2Opts.WithZlib()),
+		"v3":   jenga.V3(jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithChecksum()),
+		"none": jenga.V3(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "./test_concurrent_" + name + ".jenga"
+			cleanFile(t, path)
+			blks := jenga.NewJenga(path, opt)
+			err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < keyCount; i++ {
+				_, err := blks.Write(fmt.Sprintf("key-%d", i), strings.NewReader(value(i)))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = blks.Close()
+
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer blks.Close()
+			hammer(t, blks)
+		})
+	}
+
+	t.Run("read write", func(t *testing.T) {
+		path := "./test_concurrent_rw.jenga"
+		cleanFile(t, path)
+		blks := jenga.NewJenga(path, jenga.V3(jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithChecksum()))
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagReadWrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		for i := 0; i < keyCount; i++ {
+			_, err := blks.Write(fmt.Sprintf("key-%d", i), strings.NewReader(value(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		wait := sync.WaitGroup{}
+		for w := 0; w < 4; w++ {
+			wait.Add(1)
+			go func(w int) {
+				defer wait.Done()
+				for i := 0; i < keyCount; i++ {
+					key := fmt.Sprintf("new-%d-%d", w, i)
+					_, err := blks.Write(key, strings.NewReader(key))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					buf := &strings.Builder{}
+					_, err = blks.Read(key, buf)
+					if err != nil {
+						t.Error(err)
+					} else if buf.String() != key {
+						t.Errorf("%s data not match: %s", key, buf.String())
+					}
+				}
+			}(w)
+		}
+		hammer(t, blks)
+		wait.Wait()
+		if len(blks.KeyList()) != keyCount*5 {
+			t.Fatal("expect keys ", keyCount*5, " but get ", len(blks.KeyList()))
+		}
+	})
+}
+
+func TestJengaStat(t *testing.T) {
+	data := strings.Repeat("stat data|", 100)
+	for name, c := range map[string]struct {
+		opt        jenga.Opt
+		compress   compressor.Type
+		originSize int64
+	}{
+		"none": {jenga.V3(), compressor.TypeNone, int64(len(data))},
+		"v3":   {jenga.V3(jengablk.BlockV2Opts.WithGzip()), compressor.TypeGzip, int64(len(data))},
+		// 不包含FeatureOriginSize时扫描不解压数据，无法获得原始大小
+		"v2": {jenga.V2(jengablk.BlockV2Opts.WithZlib()), compressor.TypeZlib, jengablk.BlkHeaderUnknownSize},
+		"v2 origin": {jenga.V2(jengablk.BlockV2Opts.WithZlib(), jengablk.BlockV2Opts.WithOriginSize()), compressor.TypeZlib, int64(len(data))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "./test_stat_" + strings.ReplaceAll(name, " ", "_") + ".jenga"
+			cleanFile(t, path)
+			blks := jenga.NewJenga(path, c.opt)
+			err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = blks.Write("a", strings.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = blks.Close()
+
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer blks.Close()
+			if !blks.Exists("a") || blks.Exists("b") {
+				t.Fatal("exists not match")
+			}
+			info, err := blks.Stat("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log(info)
+			if info.Key != "a" || info.Compress != c.compress || info.OriginSize != c.originSize {
+				t.Fatal("info not match: ", info)
+			}
+			if c.compress == compressor.TypeNone {
+				f, err := os.Open(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				buf := make([]byte, info.Size)
+				_, err = f.ReadAt(buf, info.Offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(buf) != data {
+					t.Fatal("offset not match")
+				}
+			} else if info.Size >= int64(len(data)) {
+				t.Fatal("expect compressed size but get ", info.Size)
+			}
+			_, err = blks.Stat("b")
+			if !jengaerr.ReadKeyNotFoundError.Equal(err) {
+				t.Fatal("expect not found error but get ", err)
+			}
+		})
+	}
+}
+
+func TestJengaCompressors(t *testing.T) {
+	data, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opt := range map[string]func(opts ...jengablk.BlocksV2Opt) jenga.Opt{
+		"gzip":   jenga.V2Gzip,
+		"zlib":   jenga.V2Zlib,
+		"zstd":   jenga.V2Zstd,
+		"lz4":    jenga.V2Lz4,
+		"snappy": jenga.V2Snappy,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "./test_" + name + ".jenga"
+			cleanFile(t, path)
+			blks := jenga.NewJenga(path, opt(jengablk.BlockV2Opts.WithChecksum()))
+			err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = blks.Write(testFile, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = blks.Close()
+
+			// 读取时根据文件头选择压缩器
+			blks = jenga.NewJenga(path, jenga.V3())
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer blks.Close()
+			buf := &bytes.Buffer{}
+			_, err = blks.Read(testFile, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatal("data not match")
+			}
+			info, err := blks.Stat(testFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressor.GetName(info.Compress.Value()) != name {
+				t.Fatal("compress type not match: ", info.Compress)
+			}
+		})
+	}
+}
+
+func TestJengaEncrypt(t *testing.T) {
+	// 多个加密分块
+	random := make([]byte, 150*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	data := map[string][]byte{
+		"a":     random,
+		"b.txt": []byte(strings.Repeat("encrypt b|", 10000)),
+		"empty": nil,
+	}
+	keys := []string{"a", "b.txt", "empty"}
+	key := bytes.Repeat([]byte{7}, jengablk.EncryptKeySize)
+	check := func(t *testing.T, blks jenga.Jenga) {
+		for _, k := range keys {
+			buf := &bytes.Buffer{}
+			_, err := blks.Read(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data[k]) {
+				t.Fatal("data not match: ", k)
+			}
+			r, err := blks.OpenReader(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := ioutil.ReadAll(r)
+			_ = r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(d, data[k]) {
+				t.Fatal("reader data not match: ", k)
+			}
+		}
+	}
+	for name, secret := range map[string]func() jenga.Opt{
+		"passphrase": func() jenga.Opt { return jenga.WithPassphrase("jenga passphrase") },
+		"key":        func() jenga.Opt { return jenga.WithKey(key) },
+	} {
+		for _, c := range []jengablk.Cipher{jengablk.CipherAES256GCM, jengablk.CipherChaCha20Poly1305} {
+			for _, stream := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%s_%s_%v", name, c, stream), func(t *testing.T) {
+					path := "./test_encrypt.jenga"
+					cleanFile(t, path)
+					opts := []jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithChecksum(),
+						jengablk.BlockV2Opts.NoCompressExt(".txt"), jengablk.BlockV2Opts.WithCipher(c)}
+					if stream {
+						opts = append(opts, jengablk.BlockV2Opts.WithStream())
+					}
+					blks := jenga.NewJenga(path, jenga.V3(opts...), secret())
+					err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, k := range keys {
+						_, err = blks.Write(k, bytes.NewReader(data[k]))
+						if err != nil {
+							t.Fatal(err)
+						}
+					}
+					_ = blks.Close()
+
+					raw, err := ioutil.ReadFile(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if bytes.Contains(raw, data["b.txt"][:100]) {
+						t.Fatal("data not encrypted")
+					}
+
+					blks = jenga.NewJenga(path, jenga.V3(), secret())
+					err = blks.Open(jenga.OpFlagReadOnly)
+					if err != nil {
+						t.Fatal(err)
+					}
+					check(t, blks)
+					_, err = blks.OpenSection("b.txt")
+					if !jengaerr.NotSupportError.Equal(err) {
+						t.Fatal("expect not support, but get: ", err)
+					}
+					_ = blks.Close()
+
+					s := jenga.NewScanner(bytes.NewReader(raw))
+					if name == "key" {
+						s.WithKey(key)
+					} else {
+						s.WithPassphrase("jenga passphrase")
+					}
+					count := 0
+					for s.Next() {
+						buf := &bytes.Buffer{}
+						_, err := s.Read(buf)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if !bytes.Equal(buf.Bytes(), data[s.Entry().Key]) {
+							t.Fatal("scan data not match: ", s.Entry().Key)
+						}
+						count++
+					}
+					if s.Err() != nil || count != len(keys) {
+						t.Fatal(s.Err(), count)
+					}
+				})
+			}
+		}
+	}
+
+	path := "./test_encrypt.jenga"
+	cleanFile(t, path)
+	blks := jenga.NewJenga(path, jenga.V3(), jenga.WithPassphrase("jenga passphrase"))
+	err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		_, err = blks.Write(k, bytes.NewReader(data[k]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = blks.Close()
+
+	t.Run("wrong key", func(t *testing.T) {
+		for _, opt := range []jenga.Opt{jenga.WithPassphrase("wrong"), jenga.WithKey(key)} {
+			blks := jenga.NewJenga(path, jenga.V3(), opt)
+			err := blks.Open(jenga.OpFlagReadOnly)
+			if !jengaerr.DecryptKeyError.Equal(err) {
+				t.Fatal("expect wrong key, but get: ", err)
+			}
+		}
+		blks := jenga.NewJenga(path, jenga.V3())
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if !jengaerr.EncryptKeyRequiredError.Equal(err) {
+			t.Fatal("expect key required, but get: ", err)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := jenga.NewScanner(bytes.NewReader(raw)).WithPassphrase("wrong")
+		if s.Next() || !jengaerr.DecryptKeyError.Equal(s.Err()) {
+			t.Fatal("expect wrong key, but get: ", s.Err())
+		}
+	})
+
+	t.Run("append and compact", func(t *testing.T) {
+		blks := jenga.NewJenga(path, jenga.V3(), jenga.WithPassphrase("jenga passphrase"))
+		err := blks.Open(jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = blks.Write("c", strings.NewReader("append c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = blks.Close()
+		data["c"] = []byte("append c")
+		defer delete(data, "c")
+
+		src := jengablk.NewV3BlockFile(path, jengablk.BlockV2Opts.WithPassphrase("jenga passphrase"))
+		err = src.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		dstPath := "./test_encrypt_compact.jenga"
+		cleanFile(t, dstPath)
+		dst := jengablk.NewV3BlockFile(dstPath, jengablk.BlockV2Opts.WithKey(key))
+		err = dst.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = src.Compact(dst)
+		_ = dst.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blks = jenga.NewJenga(dstPath, jenga.V3(), jenga.WithKey(key))
+		err = blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		keys := append(keys, "c")
+		for _, k := range keys {
+			buf := &bytes.Buffer{}
+			_, err := blks.Read(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data[k]) {
+				t.Fatal("data not match: ", k)
+			}
+		}
+	})
+
+	t.Run("entity salt", func(t *testing.T) {
+		// 每个实体使用随机salt派生的子密钥，相同的数据加密后不同
+		saltPath := "./test_encrypt_salt.jenga"
+		cleanFile(t, saltPath)
+		f := jengablk.NewV3BlockFile(saltPath, jengablk.BlockV2Opts.WithKey(key))
+		err := f.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"x", "y"} {
+			_, err = f.WriteBlock(k, strings.NewReader("same data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = f.Close()
+		raw, err := ioutil.ReadFile(saltPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := f.StatBlock("x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := f.StatBlock("y")
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(raw[x.Offset:x.Offset+16], raw[y.Offset:y.Offset+16]) {
+			t.Fatal("expect different salt")
+		}
+		if bytes.Equal(raw[x.Offset+16:x.Offset+x.Size], raw[y.Offset+16:y.Offset+y.Size]) {
+			t.Fatal("expect different cipher text")
+		}
+		// salt被修改时无法解密
+		raw[x.Offset] ^= 1
+		err = ioutil.WriteFile(saltPath, raw, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = f.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		_, err = f.ReadBlockByKey("x", ioutil.Discard)
+		if !jengaerr.ReadDecryptFailedError.Equal(err) {
+			t.Fatal("expect decrypt failed, but get: ", err)
+		}
+		_, err = f.ReadBlockByKey("y", ioutil.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := jengablk.NewV3BlockFile(path, jengablk.BlockV2Opts.WithPassphrase("jenga passphrase"))
+		err = src.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := src.StatBlock("a")
+		_ = src.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[info.Offset+info.Size/2] ^= 1
+		err = ioutil.WriteFile(path, raw, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blks := jenga.NewJenga(path, jenga.V3(), jenga.WithPassphrase("jenga passphrase"))
+		err = blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		_, err = blks.Read("a", ioutil.Discard)
+		if !jengaerr.ReadDecryptFailedError.Equal(err) {
+			t.Fatal("expect decrypt failed, but get: ", err)
+		}
+		_, err = blks.Read("b.txt", ioutil.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("v1", func(t *testing.T) {
+		blks := jenga.NewJenga(path, jenga.WithKey(key))
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if !jengaerr.NotSupportError.Equal(err) {
+			t.Fatal("expect not support, but get: ", err)
+		}
+	})
+
+	t.Run("option order", func(t *testing.T) {
+		// 口令可位于V3选项之前
+		blks := jenga.NewJenga(path, jenga.WithPassphrase("jenga passphrase"), jenga.V3())
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		_, err = blks.Read("b.txt", ioutil.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestJengaEncryptKey(t *testing.T) {
+	data := map[string]string{
+		"customer-10086/config.json": strings.Repeat("encrypt key|", 1000),
+		"customer-10010/config.json": "config",
+	}
+	keys := []string{"customer-10086/config.json", "customer-10010/config.json"}
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream_%v", stream), func(t *testing.T) {
+			path := "./test_encrypt_key.jenga"
+			cleanFile(t, path)
+			opts := []jengablk.BlocksV2Opt{jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithMeta(),
+				jengablk.BlockV2Opts.WithEncryptKey()}
+			if stream {
+				opts = append(opts, jengablk.BlockV2Opts.WithStream())
+			}
+			blks := jenga.NewJenga(path, jenga.V3(opts...), jenga.WithPassphrase("jenga passphrase"))
+			err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range keys {
+				_, err = blks.WriteWithMeta(k, jenga.EntryMeta{Attrs: map[string]string{"k": "v"}}, strings.NewReader(data[k]))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = blks.Close()
+
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(raw, []byte("customer")) {
+				t.Fatal("key not encrypted")
+			}
+			h, err := jengablk.ReadFileHeader(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !h.HasFeature(jengablk.FeatureEncryptKey) {
+				t.Fatal("expect encrypt-key feature")
+			}
+
+			blks = jenga.NewJenga(path, jenga.V3())
+			err = blks.Open(jenga.OpFlagReadOnly)
+			if !jengaerr.EncryptKeyRequiredError.Equal(err) {
+				t.Fatal("expect key required, but get: ", err)
+			}
+
+			check := func(t *testing.T) {
+				blks := jenga.NewJenga(path, jenga.V3(), jenga.WithPassphrase("jenga passphrase"))
+				err := blks.Open(jenga.OpFlagReadOnly)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer blks.Close()
+				if len(blks.KeyList()) != len(keys) {
+					t.Fatal("expect keys: ", keys, " but get: ", blks.KeyList())
+				}
+				for _, k := range keys {
+					buf := &bytes.Buffer{}
+					_, err := blks.Read(k, buf)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if buf.String() != data[k] {
+						t.Fatal("data not match: ", k)
+					}
+				}
+			}
+			check(t)
+
+			s := jenga.NewScanner(bytes.NewReader(raw)).WithPassphrase("jenga passphrase")
+			var scanned []string
+			for s.Next() {
+				scanned = append(scanned, s.Entry().Key)
+			}
+			if s.Err() != nil || strings.Join(scanned, ",") != strings.Join(keys, ",") {
+				t.Fatal(s.Err(), scanned)
+			}
+
+			// 尾部索引缺失时全量扫描并解密key
+			err = ioutil.WriteFile(path, raw[:len(raw)-jengablk.BlkFileV3FooterSize], 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t)
+		})
+	}
+
+	t.Run("without secret", func(t *testing.T) {
+		path := "./test_encrypt_key.jenga"
+		cleanFile(t, path)
+		blks := jenga.NewJenga(path, jenga.V3(jengablk.BlockV2Opts.WithEncryptKey()))
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if !jengaerr.EncryptKeyRequiredError.Equal(err) {
+			t.Fatal("expect key required, but get: ", err)
+		}
+	})
+}
+
+func TestJengaSign(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	pub := priv.Public().(ed25519.PublicKey)
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+	data := map[string]string{
+		"a.txt": strings.Repeat("signed data|", 1000),
+		"b.txt": "b",
+	}
+	keys := []string{"a.txt", "b.txt"}
+	write := func(t *testing.T, path string, v jenga.Opt) {
+		blks := jenga.NewJenga(path, v)
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			_, err = blks.Write(k, strings.NewReader(data[k]))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = blks.Close()
+	}
+	check := func(t *testing.T, path string, opts ...jenga.Opt) error {
+		blks := jenga.NewJenga(path, append([]jenga.Opt{jenga.V3()}, opts...)...)
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			return err
+		}
+		defer blks.Close()
+		if len(blks.KeyList()) != len(keys) {
+			t.Fatal("expect keys: ", keys, " but get: ", blks.KeyList())
+		}
+		for _, k := range keys {
+			buf := &bytes.Buffer{}
+			_, err := blks.Read(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != data[k] {
+				t.Fatal("data not match: ", k)
+			}
+		}
+		return nil
+	}
+
+	t.Run("embedded", func(t *testing.T) {
+		path := "./test_sign.jenga"
+		cleanFile(t, path)
+		write(t, path, jenga.V3(jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithStream()))
+		err := check(t, path, jenga.RequireSignature(pub))
+		if !jengaerr.SignatureNotFoundError.Equal(err) {
+			t.Fatal("expect not signed, but get: ", err)
+		}
+
+		m, err := jenga.Sign(path, priv, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm, err := jenga.Verify(path, pub, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm.Digest != m.Digest || vm.Size != m.Size || vm.Root != m.Root {
+			t.Fatal("manifest not match")
+		}
+		// 签名包含Merkle根
+		blks := jenga.NewJenga(path, jenga.V3())
+		err = blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, err := blks.MerkleRoot()
+		_ = blks.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(root, m.Root[:]) {
+			t.Fatal("merkle root not match")
+		}
+		if _, err := jenga.Verify(path, other, nil); !jengaerr.SignatureVerifyError.Equal(err) {
+			t.Fatal("expect verify failed, but get: ", err)
+		}
+		if err := check(t, path, jenga.RequireSignature(pub)); err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := jenga.NewScanner(bytes.NewReader(raw))
+		var scanned []string
+		for s.Next() {
+			scanned = append(scanned, s.Entry().Key)
+		}
+		if s.Err() != nil || strings.Join(scanned, ",") != strings.Join(keys, ",") {
+			t.Fatal(s.Err(), scanned)
+		}
+
+		// 修改实体数据
+		tampered := append([]byte{}, raw...)
+		tampered[int(m.Size)-1] ^= 0xff
+		err = ioutil.WriteFile(path, tampered, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = check(t, path, jenga.RequireSignature(pub))
+		if !jengaerr.SignatureVerifyError.Equal(err) {
+			t.Fatal("expect verify failed, but get: ", err)
+		}
+
+		// footer缺失时全量扫描，跳过签名section
+		err = ioutil.WriteFile(path, raw[:len(raw)-jengablk.BlkFileV3FooterSize], 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := check(t, path); err != nil {
+			t.Fatal(err)
+		}
+
+		// 签名之后追加数据，签名失效
+		err = ioutil.WriteFile(path, raw, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blks = jenga.NewJenga(path, jenga.V3())
+		err = blks.Open(jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = blks.Write("c.txt", strings.NewReader("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = blks.Close()
+		if _, err := jenga.Verify(path, pub, nil); !jengaerr.SignatureNotFoundError.Equal(err) {
+			t.Fatal("expect not signed, but get: ", err)
+		}
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		path := "./test_sign.jenga"
+		cleanFile(t, path)
+		write(t, path, jenga.V2(jengablk.BlockV2Opts.WithChecksum()))
+		if _, err := jenga.Sign(path, priv, true); !jengaerr.NotSupportError.Equal(err) {
+			t.Fatal("expect not support, but get: ", err)
+		}
+		m, err := jenga.Sign(path, priv, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := m.Bytes()
+		if _, err := jenga.Verify(path, pub, sig); err != nil {
+			t.Fatal(err)
+		}
+		if err := check(t, path, jenga.RequireSignature(pub), jenga.WithDetachedSignature(sig)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jenga.Verify(path, pub, sig[:len(sig)-1]); !jengaerr.SignatureVerifyError.Equal(err) {
+			t.Fatal("expect verify failed, but get: ", err)
+		}
+		sig[len(sig)-1] ^= 0xff
+		err = check(t, path, jenga.RequireSignature(pub), jenga.WithDetachedSignature(sig))
+		if !jengaerr.SignatureVerifyError.Equal(err) {
+			t.Fatal("expect verify failed, but get: ", err)
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		path := "./test_sign.jenga"
+		cleanFile(t, path)
+		write(t, path, jenga.V3(jengablk.BlockV2Opts.WithPassphrase("jenga passphrase"), jengablk.BlockV2Opts.WithEncryptKey()))
+		secret := jengablk.BlockV2Opts.WithPassphrase("jenga passphrase")
+		_, err := jenga.Sign(path, priv, true, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jenga.Verify(path, pub, nil, secret); err != nil {
+			t.Fatal(err)
+		}
+		err = check(t, path, jenga.WithPassphrase("jenga passphrase"), jenga.RequireSignature(pub))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestJengaMerkle(t *testing.T) {
+	path := "./test_merkle.jenga"
+	write := func(t *testing.T, v jenga.Opt, data map[string]string, keys []string) {
+		cleanFile(t, path)
+		blks := jenga.NewJenga(path, v)
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			_, err = blks.Write(k, strings.NewReader(data[k]))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = blks.Close()
+	}
+	verify := func(t *testing.T, data map[string]string, keys []string, opts ...jenga.Opt) []byte {
+		blks := jenga.NewJenga(path, append([]jenga.Opt{jenga.V3()}, opts...)...)
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		root, err := blks.MerkleRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, k := range keys {
+			p, err := blks.Proof(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err = jenga.ParseMerkleProof(p.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Index != uint64(i) || p.Size != uint64(len(keys)) {
+				t.Fatal("proof not match: ", p.Index, p.Size)
+			}
+			err = jenga.VerifyEntry(k, strings.NewReader(data[k]), root, p)
+			if err != nil {
+				t.Fatal(k, err)
+			}
+			err = jenga.VerifyEntry(k, strings.NewReader(data[k]+"x"), root, p)
+			if !jengaerr.MerkleVerifyError.Equal(err) {
+				t.Fatal("expect verify failed, but get: ", err)
+			}
+			err = jenga.VerifyEntry(k+"x", strings.NewReader(data[k]), root, p)
+			if !jengaerr.MerkleVerifyError.Equal(err) {
+				t.Fatal("expect verify failed, but get: ", err)
+			}
+			if len(keys) > 1 {
+				other := *p
+				other.Index = (p.Index + 1) % p.Size
+				err = jenga.VerifyEntry(k, strings.NewReader(data[k]), root, &other)
+				if !jengaerr.MerkleVerifyError.Equal(err) {
+					t.Fatal("expect verify failed, but get: ", err)
+				}
+			}
+		}
+		if _, err := blks.Proof("not exists"); !jengaerr.ReadKeyNotFoundError.Equal(err) {
+			t.Fatal("expect key not found, but get: ", err)
+		}
+		return root
+	}
+
+	t.Run("size", func(t *testing.T) {
+		for size := 0; size <= 9; size++ {
+			data := map[string]string{}
+			var keys []string
+			for i := 0; i < size; i++ {
+				k := fmt.Sprintf("key-%d", i)
+				data[k] = strings.Repeat(k, i*100)
+				keys = append(keys, k)
+			}
+			write(t, jenga.V3(jengablk.BlockV2Opts.WithGzip()), data, keys)
+			verify(t, data, keys)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		data := map[string]string{"a": "a", "b": "b", "c": strings.Repeat("c", 100000)}
+		write(t, jenga.V3(jengablk.BlockV2Opts.WithStream()), data, []string{"a", "b", "c"})
+		root := verify(t, data, []string{"a", "b", "c"})
+
+		blks := jenga.NewJenga(path, jenga.V3(jengablk.BlockV2Opts.AllowOverwrite()))
+		err := blks.Open(jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = blks.Write("a", strings.NewReader("new a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = blks.Delete("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = blks.Close()
+		data["a"] = "new a"
+		newRoot := verify(t, data, []string{"c", "a"})
+		if bytes.Equal(root, newRoot) {
+			t.Fatal("root not changed")
+		}
+
+		// footer缺失时读取数据计算摘要
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256([]byte(data["a"]))
+		if !bytes.Contains(raw, digest[:]) {
+			t.Fatal("expect digest in trailer")
+		}
+		err = ioutil.WriteFile(path, raw[:len(raw)-jengablk.BlkFileV3FooterSize], 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(verify(t, data, []string{"c", "a"}), newRoot) {
+			t.Fatal("root not match")
+		}
+
+		// 缺失摘要的实体在Close时不解压计算，不写入Merkle section，读取时计算
+		blks = jenga.NewJenga(path, jenga.V3())
+		err = blks.Open(jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = blks.Write("d", strings.NewReader("d"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = blks.Close()
+		data["d"] = "d"
+		raw, err = ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest = sha256.Sum256([]byte(data["c"]))
+		if bytes.Contains(raw, digest[:]) {
+			t.Fatal("expect no digest in trailer")
+		}
+		verify(t, data, []string{"c", "a", "d"})
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		data := map[string]string{"a": "secret a", "b": "secret b"}
+		write(t, jenga.V3(jengablk.BlockV2Opts.WithPassphrase("jenga passphrase")), data, []string{"a", "b"})
+		verify(t, data, []string{"a", "b"}, jenga.WithPassphrase("jenga passphrase"))
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256([]byte(data["a"]))
+		if bytes.Contains(raw, digest[:]) {
+			t.Fatal("digest not encrypted")
+		}
+	})
+
+	t.Run("V2", func(t *testing.T) {
+		write(t, jenga.V2(), map[string]string{"a": "a"}, []string{"a"})
+		blks := jenga.NewJenga(path, jenga.V2())
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		if _, err := blks.MerkleRoot(); !jengaerr.MerkleNotFoundError.Equal(err) {
+			t.Fatal("expect not support, but get: ", err)
+		}
+	})
+}
+
+func TestJengaCommit(t *testing.T) {
+	path := "./test_commit.jenga"
+	data := map[string]string{
+		"a": strings.Repeat("commit a|", 1000),
+		"b": strings.Repeat("commit b|", 10),
+		"c": "c",
+	}
+	// 实体结束位置（V3格式为尾部的位置）
+	entitiesEnd := func(raw []byte, v3 bool) int {
+		if !v3 {
+			return len(raw)
+		}
+		return int(binary.BigEndian.Uint64(raw[len(raw)-jengablk.BlkFileV3FooterSize:]))
+	}
+	write := func(t *testing.T, v jenga.Opt, keys ...string) []byte {
+		cleanFile(t, path)
+		blks := jenga.NewJenga(path, v)
+		err := blks.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			_, err = blks.Write(k, strings.NewReader(data[k]))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = blks.Close()
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	check := func(t *testing.T, v jenga.Opt, keys ...string) {
+		blks := jenga.NewJenga(path, v)
+		err := blks.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blks.Close()
+		list := blks.KeyList()
+		sort.Strings(list)
+		if strings.Join(list, ",") != strings.Join(keys, ",") {
+			t.Fatal("expect keys: ", keys, " but get: ", list)
+		}
+		for _, k := range keys {
+			buf := &bytes.Buffer{}
+			_, err := blks.Read(k, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != data[k] {
+				t.Fatal("data not match: ", k)
+			}
+		}
+	}
+
+	for name, v := range map[string]func() jenga.Opt{
+		"v2": func() jenga.Opt {
+			return jenga.V2(jengablk.BlockV2Opts.WithCommit(), jengablk.BlockV2Opts.WithChecksum())
+		},
+		"v3": func() jenga.Opt {
+			return jenga.V3(jengablk.BlockV2Opts.WithGzip(), jengablk.BlockV2Opts.WithMeta())
+		},
+		"stream": func() jenga.Opt {
+			return jenga.V3(jengablk.BlockV2Opts.WithStream(), jengablk.BlockV2Opts.WithChecksum())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			v3 := name != "v2"
+			start := entitiesEnd(write(t, v(), "a"), v3)
+			raw := write(t, v(), "a", "b")
+			end := entitiesEnd(raw, v3)
+			if !bytes.Equal(raw[:start], write(t, v(), "a")[:start]) {
+				t.Fatal("prefix not match")
+			}
+			// 在实体b的任意位置中断
+			for cut := start + 1; cut < end; cut++ {
+				err := ioutil.WriteFile(path, raw[:cut], 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+				check(t, v(), "a")
+				fi, _ := os.Stat(path)
+				if fi.Size() != int64(cut) {
+					t.Fatal("read only open should not modify file")
+				}
+				n, err := jenga.Recover(path)
+				if err != nil {
+					t.Fatal(cut, err)
+				}
+				if n != int64(cut-start) {
+					t.Fatal("expect drop ", cut-start, " but get ", n)
+				}
+			}
+			n, err := jenga.Recover(path)
+			if err != nil || n != 0 {
+				t.Fatal("expect nothing dropped, but get: ", n, err)
+			}
+
+			// 恢复后可继续写入
+			blks := jenga.NewJenga(path, v())
+			err = blks.Open(jenga.OpFlagWriteOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = blks.Write("c", strings.NewReader(data["c"]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = blks.Close()
+			check(t, v(), "a", "c")
+		})
+	}
+
+	t.Run("commit magic in payload", func(t *testing.T) {
+		// 数据中包含与提交记录相同的字节时仍可识别未提交的尾部
+		fake := &bytes.Buffer{}
+		for _, size := range []uint64{1, 10, 30} {
+			fake.WriteString("payload|")
+			_ = binary.Write(fake, binary.BigEndian, jengablk.BlkCommitMagic)
+			_ = binary.Write(fake, binary.BigEndian, size)
+		}
+		data["m"] = fake.String() + strings.Repeat("payload|", 10)
+		defer delete(data, "m")
+		start := entitiesEnd(write(t, jenga.V3(), "a"), true)
+		raw := write(t, jenga.V3(), "a", "m")
+		end := entitiesEnd(raw, true)
+		for cut := start + 1; cut < end; cut++ {
+			err := ioutil.WriteFile(path, raw[:cut], 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, jenga.V3(), "a")
+			n, err := jenga.Recover(path)
+			if err != nil {
+				t.Fatal(cut, err)
+			}
+			if n != int64(cut-start) {
+				t.Fatal("expect drop ", cut-start, " but get ", n)
+			}
+		}
+	})
+
+	t.Run("seek write interrupted", func(t *testing.T) {
+		// 写入数据后、回写实体头前中断：实体头为0且没有提交记录
+		start := entitiesEnd(write(t, jenga.V3(), "a"), true)
+		raw := write(t, jenga.V3(), "a", "b")
+		end := entitiesEnd(raw, true)
+		torn := append([]byte{}, raw[:end-jengablk.BlkCommitSize]...)
+		head := start + 1 + len("b") + 2
+		copy(torn[head:], make([]byte, 16))
+		err := ioutil.WriteFile(path, torn, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, jenga.V3(), "a")
+		n, err := jenga.Recover(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(torn)-start) {
+			t.Fatal("expect drop ", len(torn)-start, " but get ", n)
+		}
+		check(t, jenga.V3(), "a")
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		// 文件中间的实体损坏时不移除之后的数据
+		start := entitiesEnd(write(t, jenga.V3(), "a"), true)
+		raw := write(t, jenga.V3(), "a", "b")
+		broken := append([]byte{}, raw[:entitiesEnd(raw, true)]...)
+		broken[start-1] ^= 0xff
+		err := ioutil.WriteFile(path, broken, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = jenga.Recover(path)
+		if !jengaerr.ReadCommitNotMatchError.Equal(err) {
+			t.Fatal("expect commit not match, but get: ", err)
+		}
+		fi, _ := os.Stat(path)
+		if fi.Size() != int64(len(broken)) {
+			t.Fatal("broken file should not be truncated")
+		}
+	})
+}
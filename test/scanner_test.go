@@ -0,0 +1,288 @@
+// Copyright (C) 2019-2021, Xiongfa Li.
+// @author xiongfa.li
+// @version V1.0
+// Description:
+
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/blk"
+	"github.com/xfali/jenga/jengaerr"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanner(t *testing.T) {
+	data := map[string]string{
+		"a":      strings.Repeat("scan a|", 10000),
+		"b/b.go": "scan b",
+		"c":      "",
+	}
+	mtime := time.Unix(1600000000, 0)
+	write := func(t *testing.T, j jenga.Jenga) {
+		err := j.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "b/b.go", "c"} {
+			_, err = j.WriteWithMeta(k, jenga.EntryMeta{Mode: 0640, ModTime: mtime}, strings.NewReader(data[k]))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = j.Write("b/b.go", strings.NewReader("scan b2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = j.Delete("c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = j.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, opts := range map[string]func(buf *bytes.Buffer) jenga.Opt{
+		"v2": func(buf *bytes.Buffer) jenga.Opt {
+			return jenga.V2(jengablk.BlockV2Opts.AllowOverwrite(), jengablk.BlockV2Opts.WithMeta())
+		},
+		"v3": func(buf *bytes.Buffer) jenga.Opt {
+			return jenga.V3(jengablk.BlockV2Opts.AllowOverwrite(), jengablk.BlockV2Opts.WithGzip(),
+				jengablk.BlockV2Opts.WithChecksum(), jengablk.BlockV2Opts.WithMeta())
+		},
+		"stream": func(buf *bytes.Buffer) jenga.Opt {
+			return jenga.V3(jengablk.BlockV2Opts.WithWriter(buf), jengablk.BlockV2Opts.AllowOverwrite(),
+				jengablk.BlockV2Opts.WithZlib(), jengablk.BlockV2Opts.WithChecksum(), jengablk.BlockV2Opts.WithMeta())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := "./test_scanner_" + name + ".jenga"
+			buf := &bytes.Buffer{}
+			if name == "stream" {
+				write(t, jenga.NewJengaWithOpts(opts(buf)))
+			} else {
+				cleanFile(t, path)
+				write(t, jenga.NewJenga(path, opts(buf)))
+				d, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				buf.Write(d)
+			}
+			raw := buf.Bytes()
+
+			// 隐藏Seek、ReadAt等方法
+			s := jenga.NewScanner(struct{ io.Reader }{bytes.NewReader(raw)})
+			ret := map[string]string{}
+			var keys []string
+			for s.Next() {
+				info := s.Entry()
+				keys = append(keys, info.Key)
+				if s.Deleted() {
+					delete(ret, info.Key)
+					continue
+				}
+				w := &strings.Builder{}
+				n, err := s.Read(w)
+				if err != nil {
+					t.Fatal(info.Key, err)
+				}
+				if n != int64(w.Len()) {
+					t.Fatal("size not match: ", n, w.Len())
+				}
+				info = s.Entry()
+				if info.OriginSize != n {
+					t.Fatal("origin size not match: ", info.OriginSize, n)
+				}
+				if info.Key != "b/b.go" || w.String() != "scan b2" {
+					if info.Mode != 0640 || !info.ModTime.Equal(mtime) {
+						t.Fatal("meta not match: ", info.EntryMeta)
+					}
+				}
+				ret[info.Key] = w.String()
+			}
+			if s.Err() != nil {
+				t.Fatal(s.Err())
+			}
+			if strings.Join(keys, ",") != "a,b/b.go,c,b/b.go,c" {
+				t.Fatal("keys not match: ", keys)
+			}
+			if len(ret) != 2 || ret["a"] != data["a"] || ret["b/b.go"] != "scan b2" {
+				t.Fatal("data not match")
+			}
+
+			// 未读取或部分读取的数据被跳过
+			s = jenga.NewScanner(bytes.NewReader(raw))
+			count := 0
+			for s.Next() {
+				if s.Entry().Key == "a" {
+					p := make([]byte, 7)
+					_, err := io.ReadFull(s.Reader(), p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(p) != "scan a|" {
+						t.Fatal("data not match: ", string(p))
+					}
+				}
+				count++
+			}
+			if s.Err() != nil || count != 5 {
+				t.Fatal("expect 5 entries but get ", count, s.Err())
+			}
+			h, err := s.Header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "stream" && !h.HasFeature(jengablk.FeatureStream) {
+				t.Fatal("expect stream feature")
+			}
+
+			// 数据不完整（截断于实体中间）
+			s = jenga.NewScanner(bytes.NewReader(raw[:len(raw)/2-1]))
+			for s.Next() {
+			}
+			if s.Err() == nil {
+				t.Fatal("expect error")
+			}
+		})
+	}
+}
+
+func TestScannerBroken(t *testing.T) {
+	buf := &bytes.Buffer{}
+	j := jenga.NewJengaWithOpts(jenga.V3(jengablk.BlockV2Opts.WithWriter(buf), jengablk.BlockV2Opts.WithChecksum(),
+		jengablk.BlockV2Opts.WithMeta()))
+	err := j.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c/c.json"} {
+		_, err = j.Write(k, strings.NewReader(strings.Repeat("broken "+k, 100)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = j.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	header := raw[:jengablk.BlkFileHeadSize]
+
+	j = jenga.NewJengaWithOpts(jenga.V3(jengablk.BlockV2Opts.WithWriter(ioutil.Discard)))
+	err = j.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = j.Write(strings.Repeat("k", 64*1024+1), strings.NewReader("key too long"))
+	if !jengaerr.WriteKeySizeError.Equal(err) {
+		t.Fatal("expect WriteKeySizeError but get ", err)
+	}
+	_ = j.Close()
+	varint := func(v uint64) []byte {
+		vi := jengablk.VarInt{}
+		vi.InitFromUInt64(v)
+		return vi.Bytes()
+	}
+	scan := func(data []byte) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("panic with data %x: %v", data, r)
+			}
+		}()
+		s := jenga.NewScanner(bytes.NewReader(data))
+		for s.Next() {
+			_, _ = s.Read(ioutil.Discard)
+		}
+		return s.Err()
+	}
+	concat := func(bs ...[]byte) []byte {
+		return bytes.Join(bs, nil)
+	}
+
+	t.Run("key size", func(t *testing.T) {
+		for _, size := range []uint64{1 << 20, 1 << 40, 1<<64 - 1} {
+			err := scan(concat(header, varint(size), []byte("key")))
+			if !jengaerr.JengaBrokenError.Equal(err) {
+				t.Fatal("expect JengaBrokenError but get ", err)
+			}
+		}
+	})
+
+	t.Run("trailer size", func(t *testing.T) {
+		// 与尾部的索引section相同的开头
+		for _, size := range []uint64{1 << 20, 1 << 40, 1<<64 - 1} {
+			err := scan(concat(header, []byte{1}, varint(size), []byte("index")))
+			if err == nil {
+				t.Fatal("expect error")
+			}
+		}
+	})
+
+	t.Run("trailer", func(t *testing.T) {
+		offset := binary.BigEndian.Uint64(raw[len(raw)-jengablk.BlkFileV3FooterSize:])
+		// 超出原有缓存上限的未知section，读取时不缓存尾部
+		big := make([]byte, 1<<20)
+		rand.New(rand.NewSource(1)).Read(big)
+		trailer := concat(raw[offset:len(raw)-jengablk.BlkFileV3FooterSize], []byte{9}, varint(uint64(len(big))), big)
+		footer := make([]byte, jengablk.BlkFileV3FooterSize)
+		binary.BigEndian.PutUint64(footer, offset)
+		binary.BigEndian.PutUint32(footer[8:], crc32.ChecksumIEEE(trailer))
+		binary.BigEndian.PutUint32(footer[12:], jengablk.BlkFileV3FooterMagic)
+		count := func(data []byte) (int, error) {
+			s := jenga.NewScanner(bytes.NewReader(data))
+			n := 0
+			for s.Next() {
+				n++
+			}
+			return n, s.Err()
+		}
+		for _, data := range [][]byte{concat(raw[:offset], trailer, footer), concat(raw[:offset], trailer)} {
+			n, err := count(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Fatal("expect 3 entities but get ", n)
+			}
+		}
+		footer[8]++
+		n, err := count(concat(raw[:offset], trailer, footer))
+		if !jengaerr.JengaBrokenError.Equal(err) {
+			t.Fatal("expect JengaBrokenError but get ", err)
+		}
+		if n != 3 {
+			t.Fatal("expect 3 entities but get ", n)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for i := 0; i < len(raw); i++ {
+			_ = scan(raw[:i])
+		}
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		rd := rand.New(rand.NewSource(1))
+		for i := 0; i < 1000; i++ {
+			data := append([]byte{}, raw...)
+			for n := rd.Intn(8) + 1; n > 0; n-- {
+				data[jengablk.BlkFileHeadSize+rd.Intn(len(data)-jengablk.BlkFileHeadSize)] = byte(rd.Intn(256))
+			}
+			_ = scan(data)
+			garbage := make([]byte, rd.Intn(64))
+			rd.Read(garbage)
+			_ = scan(concat(header, garbage))
+		}
+	})
+}
@@ -7,9 +7,11 @@ package test
 
 import (
 	"github.com/xfali/jenga"
+	"github.com/xfali/jenga/jengaerr"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var testFile = "./test.json"
@@ -52,4 +54,54 @@ func TestTar(t *testing.T) {
 		}
 		t.Log(b.String())
 	})
+
+	t.Run("read-write", func(t *testing.T) {
+		tar := jenga.NewTar("./test.tar")
+		err := tar.Open(jenga.OpFlagReadWrite)
+		if !jengaerr.NotSupportError.Equal(err) {
+			t.Fatal("expect NotSupportError but get ", err)
+		}
+	})
+}
+
+func TestTarMeta(t *testing.T) {
+	cleanFile(t, "./test.tar")
+	modTime := time.Unix(1600000000, 0)
+	t.Run("write", func(t *testing.T) {
+		tar := jenga.NewTar("./test.tar")
+		err := tar.Open(jenga.OpFlagCreate | jenga.OpFlagWriteOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tar.Close()
+		_, err = tar.WriteWithMeta(testFile, jenga.EntryMeta{
+			Mode:    0600,
+			ModTime: modTime,
+			Attrs: map[string]string{
+				"content-type": "application/json",
+			},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		tar := jenga.NewTar("./test.tar")
+		err := tar.Open(jenga.OpFlagReadOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tar.Close()
+		info, err := tar.Stat(testFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode.Perm() != 0600 || !info.ModTime.Equal(modTime) || info.Attrs["content-type"] != "application/json" {
+			t.Fatal("meta not match: ", info)
+		}
+		if info.Size <= 0 || info.OriginSize != info.Size || !tar.Exists(testFile) || tar.Exists("not_exists") {
+			t.Fatal("info not match: ", info)
+		}
+	})
 }